@@ -8,40 +8,53 @@ import (
 	"fmt"
 	"gok-pi/battery/entity"
 	"gok-pi/internal/lib/sl"
+	"gok-pi/metrics/observers"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
 	maxRetry     = 5
-	retryStep    = 3
 	opModeAuto   = "2"
 	opModeManual = "1"
 )
 
+// retryStep is the delay before the first retry; each further retry waits one step longer.
+var retryStep = 3 * time.Second
+
 var httpClient = &http.Client{}
 
 type ApiClient struct {
-	url   string
-	token string
-	log   *slog.Logger
+	name     string
+	url      string
+	token    string
+	observer observers.Observer
+	log      *slog.Logger
 }
 
-func New(url, token string, log *slog.Logger) *ApiClient {
+// New creates a client for the battery controller at url. The name labels the request metrics.
+func New(name, url, token string, log *slog.Logger) *ApiClient {
 	log.With(
 		slog.String("url", url),
 		sl.Secret("token", token),
 	).Info("creating api client")
 	return &ApiClient{
-		url:   url,
-		token: token,
-		log:   log.With(sl.Module("client")),
+		name:     name,
+		url:      url,
+		token:    token,
+		observer: observers.Nop{},
+		log:      log.With(sl.Module("client")),
 	}
 }
 
+func (c *ApiClient) SetObserver(observer observers.Observer) {
+	c.observer = observer
+}
+
 func (c *ApiClient) Status() (*entity.SystemStatus, error) {
 	body, err := c.requestWithRetry(http.MethodGet, nil, c.url, "status")
 	if err != nil {
@@ -96,6 +109,10 @@ func (c *ApiClient) fullPath(params ...string) string {
 // After the maximum number of retries, it returns an error indicating the request failure.
 func (c *ApiClient) requestWithRetry(method string, data interface{}, params ...string) ([]byte, error) {
 	path := c.fullPath(params...)
+	endpoint := ""
+	if len(params) > 1 {
+		endpoint = params[1]
+	}
 	log := c.log.With(
 		slog.String("url", path),
 		slog.String("method", method),
@@ -111,19 +128,23 @@ func (c *ApiClient) requestWithRetry(method string, data interface{}, params ...
 	}
 
 	for i := 0; i < maxRetry; i++ {
-		responseBody, err := c.doRequest(method, path, bytes.NewReader(body))
+		if i > 0 {
+			c.observer.IncApiRetry(c.name, endpoint)
+		}
+		responseBody, err := c.doRequest(method, path, endpoint, bytes.NewReader(body))
 		if err == nil {
 			return responseBody, nil
 		}
 		log.With(
 			slog.Int("attempt", i+1),
 		).Debug("retrying request")
-		time.Sleep(time.Duration((i+1)*retryStep) * time.Second)
+		time.Sleep(time.Duration(i+1) * retryStep)
 	}
+	c.observer.IncApiFailure(c.name, endpoint)
 	return nil, fmt.Errorf("request failed after %d retries", maxRetry)
 }
 
-func (c *ApiClient) doRequest(method, url string, reader io.Reader) ([]byte, error) {
+func (c *ApiClient) doRequest(method, url, endpoint string, reader io.Reader) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -133,9 +154,12 @@ func (c *ApiClient) doRequest(method, url string, reader io.Reader) ([]byte, err
 		sl.Secret("token", c.token),
 		slog.String("method", method),
 	)
+	code := "error"
 	t1 := time.Now()
 	defer func() {
-		log = log.With(slog.Float64("duration", time.Since(t1).Seconds()))
+		duration := time.Since(t1)
+		c.observer.ObserveApiRequest(c.name, endpoint, code, duration)
+		log = log.With(slog.Float64("duration", duration.Seconds()))
 		if err != nil {
 			log.Error("api request", sl.Err(err))
 		} else {
@@ -161,6 +185,7 @@ func (c *ApiClient) doRequest(method, url string, reader io.Reader) ([]byte, err
 		_ = Body.Close()
 	}(resp.Body)
 
+	code = strconv.Itoa(resp.StatusCode)
 	log = log.With(slog.Int("status", resp.StatusCode))
 	if resp.StatusCode >= 400 {
 		err = fmt.Errorf("received status code: %d", resp.StatusCode)
@@ -194,9 +219,12 @@ func (c *ApiClient) doRequestChangeConfig(parameter, value string) error {
 		slog.String("method", "PUT"),
 		slog.String(parameter, value),
 	)
+	code := "error"
 	t1 := time.Now()
 	defer func() {
-		log = log.With(slog.Float64("duration", time.Since(t1).Seconds()))
+		duration := time.Since(t1)
+		c.observer.ObserveApiRequest(c.name, "configurations", code, duration)
+		log = log.With(slog.Float64("duration", duration.Seconds()))
 		if err != nil {
 			log.Error("change config request", sl.Err(err))
 		} else {
@@ -219,6 +247,7 @@ func (c *ApiClient) doRequestChangeConfig(parameter, value string) error {
 		return err
 	}
 
+	code = strconv.Itoa(resp.StatusCode)
 	log = log.With(slog.Int("status", resp.StatusCode))
 	if resp.StatusCode >= 400 {
 		err = fmt.Errorf("received status code: %d", resp.StatusCode)
@@ -0,0 +1,201 @@
+package apiclient
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// request is a request received by the test server.
+type request struct {
+	method      string
+	path        string
+	token       string
+	contentType string
+	body        string
+}
+
+// testServer records all requests and answers them with the given handler.
+type testServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []request
+}
+
+func newTestServer(t *testing.T, handler func(w http.ResponseWriter, n int)) *testServer {
+	t.Helper()
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mutex.Lock()
+		s.requests = append(s.requests, request{
+			method:      r.Method,
+			path:        r.URL.Path,
+			token:       r.Header.Get("Auth-Token"),
+			contentType: r.Header.Get("Content-Type"),
+			body:        string(body),
+		})
+		n := len(s.requests)
+		s.mutex.Unlock()
+		handler(w, n)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) received() []request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]request(nil), s.requests...)
+}
+
+func newTestClient(t *testing.T, url string) *ApiClient {
+	t.Helper()
+	step := retryStep
+	retryStep = time.Millisecond
+	t.Cleanup(func() { retryStep = step })
+	return New("b1", url+"/api/v2", "secret-token", slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func ok(w http.ResponseWriter, _ int) {
+	_, _ = io.WriteString(w, `{}`)
+}
+
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(c *ApiClient) error
+		want []request
+	}{
+		{
+			name: "status",
+			call: func(c *ApiClient) error {
+				_, err := c.Status()
+				return err
+			},
+			want: []request{{method: http.MethodGet, path: "/api/v2/status", contentType: "application/json"}},
+		},
+		{
+			name: "start discharge",
+			call: func(c *ApiClient) error { return c.StartDischarge(800) },
+			want: []request{{method: http.MethodPost, path: "/api/v2/setpoint/discharge/800", contentType: "application/json"}},
+		},
+		{
+			name: "stop discharge",
+			call: func(c *ApiClient) error { return c.StopDischarge() },
+			want: []request{{method: http.MethodPost, path: "/api/v2/setpoint/discharge/0", contentType: "application/json"}},
+		},
+		{
+			name: "switch to manual",
+			call: func(c *ApiClient) error { return c.SwitchOperatingModeToManual(opModeAuto) },
+			want: []request{{
+				method:      http.MethodPut,
+				path:        "/api/v2/configurations",
+				contentType: "application/x-www-form-urlencoded",
+				body:        "EM_OperatingMode=1",
+			}},
+		},
+		{
+			name: "switch to auto",
+			call: func(c *ApiClient) error { return c.SwitchOperatingModeToAuto(opModeManual) },
+			want: []request{{
+				method:      http.MethodPut,
+				path:        "/api/v2/configurations",
+				contentType: "application/x-www-form-urlencoded",
+				body:        "EM_OperatingMode=2",
+			}},
+		},
+		{
+			name: "already manual",
+			call: func(c *ApiClient) error { return c.SwitchOperatingModeToManual(opModeManual) },
+		},
+		{
+			name: "already auto",
+			call: func(c *ApiClient) error { return c.SwitchOperatingModeToAuto(opModeAuto) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, ok)
+			c := newTestClient(t, server.URL)
+
+			if err := tt.call(c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := server.received()
+			if len(got) != len(tt.want) {
+				t.Fatalf("received %d requests, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				want.token = "secret-token"
+				if got[i] != want {
+					t.Errorf("request %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusParsesBody(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, _ int) {
+		_, _ = io.WriteString(w, `{"USOC": 42, "OperatingMode": "2", "Pac_total_W": -150}`)
+	})
+	status, err := newTestClient(t, server.URL).Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.USOC != 42 || status.OperatingMode != "2" || status.PacTotalW != -150 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		wantErr  bool
+		requests int
+	}{
+		{name: "first attempt succeeds", failures: 0, requests: 1},
+		{name: "succeeds after failures", failures: 2, requests: 3},
+		{name: "last attempt succeeds", failures: maxRetry - 1, requests: maxRetry},
+		{name: "all attempts fail", failures: maxRetry, wantErr: true, requests: maxRetry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, func(w http.ResponseWriter, n int) {
+				if n <= tt.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				ok(w, n)
+			})
+			err := newTestClient(t, server.URL).StartDischarge(500)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if n := len(server.received()); n != tt.requests {
+				t.Errorf("received %d requests, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestChangeConfigIsNotRetried(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, _ int) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	err := newTestClient(t, server.URL).SwitchOperatingModeToManual(opModeAuto)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if n := len(server.received()); n != 1 {
+		t.Errorf("received %d requests, want 1", n)
+	}
+}
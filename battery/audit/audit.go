@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"gok-pi/internal/lib/rotate"
+	"os"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionManual = "manual"
+	ActionAuto   = "auto"
+	ActionStart  = "start"
+	ActionPower  = "power"
+	ActionStop   = "stop"
+	// ActionPolled is written by the first poll after control actions with the SoC after them.
+	ActionPolled = "polled"
+)
+
+// Sources of control actions besides the API users of commands, e.g. "mqtt".
+const (
+	SourceSchedule = "schedule"
+	SourceStrategy = "strategy"
+	SourceWatchdog = "watchdog"
+	SourceShutdown = "shutdown"
+)
+
+// Entry is one setpoint or operating mode change. Reason is the cause given by the
+// strategy or command, Source who triggered it: the schedule, the strategy outside any
+// schedule, the watchdog or an API user. SocBefore is the USoC of the last poll before
+// the action. Entries are written right away, so SocAfter is only set on the polled
+// entry following the actions.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Battery   string    `json:"battery"`
+	Action    string    `json:"action"`
+	Power     int       `json:"power,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Schedule  string    `json:"schedule,omitempty"`
+	Source    string    `json:"source"`
+	SocBefore float64   `json:"soc_before"`
+	SocAfter  *float64  `json:"soc_after,omitempty"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// SetResult records the outcome of the action.
+func (e *Entry) SetResult(err error) {
+	e.Result = "ok"
+	e.Error = ""
+	if err != nil {
+		e.Result = "error"
+		e.Error = err.Error()
+	}
+}
+
+// Log appends entries as JSON lines to a rotated file. It is safe for concurrent use.
+type Log struct {
+	file *rotate.File
+}
+
+// Open opens the audit log at path, rotating it at maxSizeMb and keeping maxFiles old files.
+func Open(path string, maxSizeMb, maxFiles int) (*Log, error) {
+	file, err := rotate.Open(path, rotate.Options{MaxSize: int64(maxSizeMb) << 20, MaxFiles: maxFiles})
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &Log{file: file}, nil
+}
+
+func (l *Log) Write(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return nil
+}
+
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Append writes a single entry without rotating the file, for short-lived processes
+// such as CLI commands that share the log with a running service.
+func Append(path string, e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package discharger
+
+import (
+	"fmt"
+	"gok-pi/integrations/notify"
+	"time"
+)
+
+// Notifier receives the alerts raised by the worker.
+type Notifier interface {
+	Notify(e notify.Event)
+}
+
+// Alerts configures when the worker raises alerts. An alert about API failures is raised
+// after FailureThreshold consecutive polls with a failed API call, and one about manual
+// mode when the battery stays in manual mode without being discharged by this worker for
+// StuckManualAfter.
+type Alerts struct {
+	FailureThreshold int
+	StuckManualAfter time.Duration
+}
+
+// alertState tracks the conditions leading to alerts between polls.
+type alertState struct {
+	failed        bool
+	lastErr       error
+	failures      int
+	manualSince   time.Time
+	manualAlerted bool
+}
+
+func (d *Discharge) SetNotifier(notifier Notifier, alerts Alerts) {
+	d.notifier = notifier
+	d.alerts = alerts
+}
+
+func (d *Discharge) notify(kind notify.Kind, title, text string) {
+	if d.notifier == nil {
+		return
+	}
+	d.notifier.Notify(notify.Event{
+		Kind:    kind,
+		Battery: d.name,
+		Time:    d.clock.Now(),
+		Title:   fmt.Sprintf("%s: %s", d.name, title),
+		Text:    text,
+	})
+}
+
+// apiFailed marks the current poll as failed.
+func (d *Discharge) apiFailed(err error) {
+	d.alert.failed = true
+	d.alert.lastErr = err
+}
+
+// checkFailures counts consecutive failed polls. It alerts once the threshold is reached
+// and again when the API recovers.
+func (d *Discharge) checkFailures() {
+	failed := d.alert.failed
+	d.alert.failed = false
+	if failed {
+		d.alert.failures++
+		if d.alert.failures == d.alerts.FailureThreshold {
+			d.notify(notify.KindApiFailures, "battery API failing",
+				fmt.Sprintf("%d consecutive polls with failed API calls, last error: %v", d.alert.failures, d.alert.lastErr))
+		}
+		return
+	}
+	if d.alerts.FailureThreshold > 0 && d.alert.failures >= d.alerts.FailureThreshold {
+		d.notify(notify.KindRecovered, "battery API recovered",
+			fmt.Sprintf("API calls succeed again after %d failed polls", d.alert.failures))
+	}
+	d.alert.failures = 0
+}
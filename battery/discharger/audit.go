@@ -0,0 +1,93 @@
+package discharger
+
+import (
+	"gok-pi/battery/audit"
+	"gok-pi/battery/entity"
+	"gok-pi/battery/strategy"
+	"gok-pi/internal/lib/sl"
+	"time"
+)
+
+// AuditWriter records the control actions of the worker.
+type AuditWriter interface {
+	Write(e audit.Entry) error
+}
+
+// trigger is the cause of the control actions currently taken.
+type trigger struct {
+	reason   string
+	schedule string
+	source   string
+}
+
+// SetAudit enables recording every setpoint and operating mode change.
+func (d *Discharge) SetAudit(writer AuditWriter) {
+	d.auditLog = writer
+}
+
+// triggerOf returns the trigger of a decision; source is empty for strategy decisions.
+func triggerOf(decision strategy.Decision, source string) trigger {
+	t := trigger{reason: decision.Reason, source: source}
+	if decision.Schedule != nil {
+		t.schedule = decision.Schedule.Label()
+	}
+	if t.source == "" {
+		t.source = audit.SourceStrategy
+		if decision.Schedule != nil {
+			t.source = audit.SourceSchedule
+		}
+	}
+	return t
+}
+
+// audit writes a control action with the current trigger; a non-empty reason replaces
+// the trigger's. The SoC after the actions is recorded by the next poll, see auditPolled.
+func (d *Discharge) audit(action string, power int, reason string, err error) {
+	if d.auditLog == nil {
+		return
+	}
+	if reason == "" {
+		reason = d.trigger.reason
+	}
+	e := audit.Entry{
+		Time:     d.clock.Now(),
+		Battery:  d.name,
+		Action:   action,
+		Power:    power,
+		Reason:   reason,
+		Schedule: d.trigger.schedule,
+		Source:   d.trigger.source,
+	}
+	if d.status != nil {
+		e.SocBefore = d.status.USOC
+	}
+	e.SetResult(err)
+	d.writeAudit(e)
+	if d.auditAfter == nil {
+		d.auditAfter = &e
+	}
+}
+
+// auditPolled writes a polled entry with the SoC after the control actions since the
+// previous successful poll, if there were any. It keeps the trigger and SoC before the
+// first of these actions.
+func (d *Discharge) auditPolled(now time.Time, status *entity.SystemStatus) {
+	if d.auditAfter == nil {
+		return
+	}
+	e := *d.auditAfter
+	d.auditAfter = nil
+	e.Time = now
+	e.Action = audit.ActionPolled
+	e.Power = 0
+	soc := status.USOC
+	e.SocAfter = &soc
+	e.SetResult(nil)
+	d.writeAudit(e)
+}
+
+func (d *Discharge) writeAudit(e audit.Entry) {
+	if err := d.auditLog.Write(e); err != nil {
+		d.log.With(sl.Err(err)).Error("writing audit log")
+	}
+}
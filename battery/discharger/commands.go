@@ -0,0 +1,227 @@
+package discharger
+
+import (
+	"errors"
+	"gok-pi/battery/entity"
+	"gok-pi/battery/strategy"
+	"log/slog"
+	"time"
+)
+
+const commandQueueSize = 8
+
+var (
+	ErrBusy            = errors.New("discharge worker busy, command dropped")
+	ErrControlDisabled = errors.New("discharge control disabled for this battery")
+	ErrNoLimits        = errors.New("battery power or SoC limit not configured, forced discharge refused")
+)
+
+// State is a snapshot of the worker published after every poll and command.
+type State struct {
+	Control     string          `json:"control"`
+	Discharging bool            `json:"discharging"`
+	Power       int             `json:"power"`
+	Schedule    string          `json:"schedule"`
+	Paused      bool            `json:"paused"`
+	Forced      bool            `json:"forced"`
+	ForcedUntil time.Time       `json:"forced_until,omitempty"`
+	SocLimit    float64         `json:"soc_limit,omitempty"`
+	Schedules   map[string]bool `json:"schedules,omitempty"`
+}
+
+// StatePublisher receives every polled status and state change of the worker.
+type StatePublisher interface {
+	PublishStatus(name string, status *entity.SystemStatus)
+	PublishState(name string, state State)
+}
+
+// override replaces the strategy decision until it expires; a zero until never expires.
+// Source is the API user who requested it.
+type override struct {
+	decision strategy.Decision
+	until    time.Time
+	source   string
+}
+
+func (d *Discharge) SetPublisher(publisher StatePublisher) {
+	d.publisher = publisher
+}
+
+// SetLimits sets the battery limits applied to external commands: forced discharge power
+// is capped at powerLimit and stops when the USoC reaches socLimit. Forced discharges are
+// refused unless both limits are positive.
+func (d *Discharge) SetLimits(powerLimit, socLimit int) {
+	d.powerLimit = powerLimit
+	d.socLimit = float64(socLimit)
+}
+
+// ForceDischarge discharges with the given power for the duration, regardless of the strategy.
+// A zero power uses the battery power limit. A zero duration discharges until the SoC limit
+// is reached or the discharge is cancelled. Like all commands it is safe to call from other
+// goroutines; source names the API user for the audit log. ErrNoLimits is returned if the
+// battery has no power or SoC limit.
+func (d *Discharge) ForceDischarge(source string, power int, duration time.Duration) error {
+	if d.powerLimit <= 0 || d.socLimit <= 0 {
+		return ErrNoLimits
+	}
+	return d.command(func(now time.Time) {
+		if power <= 0 || power > d.powerLimit {
+			power = d.powerLimit
+		}
+		o := &override{
+			decision: strategy.Decision{Mode: strategy.ModeDischarge, Power: power, Reason: "forced"},
+			source:   source,
+		}
+		if duration > 0 {
+			o.until = now.Add(duration)
+		}
+		d.override = o
+		d.log.With(
+			slog.Int("power", power),
+			slog.Duration("duration", duration),
+			slog.String("source", source),
+		).Info("forced discharge requested")
+	})
+}
+
+// CancelDischarge stops a forced or scheduled discharge. A running schedule is suppressed
+// until the strategy's next boundary, so the following window starts as planned.
+func (d *Discharge) CancelDischarge(source string) error {
+	return d.command(func(now time.Time) {
+		o := &override{decision: strategy.Decision{Mode: strategy.ModeAuto, Reason: "cancelled"}, source: source}
+		if b, ok := d.strategy.(strategy.Boundaries); ok {
+			if next, ok := b.NextBoundary(now); ok {
+				o.until = next
+			}
+		}
+		if o.until.IsZero() {
+			d.override = nil
+		} else {
+			d.override = o
+		}
+		d.log.With(slog.String("source", source)).Info("discharge cancelled")
+	})
+}
+
+// Pause suspends all control actions, stopping a running discharge, until resumed.
+// The worker enters Paused once the battery is back in automatic mode.
+func (d *Discharge) Pause(source string, paused bool) error {
+	return d.command(func(now time.Time) {
+		if d.paused == paused {
+			return
+		}
+		d.paused = paused
+		d.pausedBy = source
+		d.log.With(slog.Bool("paused", paused), slog.String("source", source)).Info("pause changed")
+		if paused {
+			d.enterPause()
+		} else if d.control == StatePaused {
+			d.transition(StateIdle, "resumed")
+		}
+	})
+}
+
+// SetSocLimit replaces the SoC limit of schedules and forced discharges; zero restores
+// the configured limits.
+func (d *Discharge) SetSocLimit(source string, limit float64) error {
+	return d.command(func(now time.Time) {
+		d.socLimitOverride = limit
+		d.log.With(slog.Float64("soc_limit", limit), slog.String("source", source)).Info("SoC limit override changed")
+	})
+}
+
+// EnableSchedule enables or disables the strategy's schedules with the given label.
+func (d *Discharge) EnableSchedule(source, label string, enabled bool) error {
+	toggler, ok := d.strategy.(strategy.ScheduleToggler)
+	if !ok {
+		return errors.New("strategy has no schedules")
+	}
+	return d.command(func(now time.Time) {
+		err := toggler.SetScheduleEnabled(label, enabled)
+		if err != nil {
+			d.log.With(slog.String("schedule", label)).Warn(err.Error())
+			return
+		}
+		d.log.With(
+			slog.String("schedule", label),
+			slog.Bool("enabled", enabled),
+			slog.String("source", source),
+		).Info("schedule toggled")
+	})
+}
+
+// command queues fn to run in the worker goroutine followed by an evaluation,
+// so that commands never race with polling.
+func (d *Discharge) command(fn func(now time.Time)) error {
+	if !d.discharge {
+		return ErrControlDisabled
+	}
+	select {
+	case d.commands <- fn:
+		return nil
+	default:
+		return ErrBusy
+	}
+}
+
+// decide returns the strategy decision unless an active override replaces it, together
+// with the API user who requested the override, if any.
+func (d *Discharge) decide(now time.Time) (strategy.Decision, string) {
+	decision := d.strategy.Decide(strategy.Input{
+		Status:   d.status,
+		Time:     now,
+		History:  d.history,
+		SocLimit: d.socLimitOverride,
+	})
+
+	if d.override == nil {
+		return decision, ""
+	}
+	if !d.override.until.IsZero() && !now.Before(d.override.until) {
+		d.log.With(slog.String("reason", d.override.decision.Reason)).Info("override expired")
+		d.override = nil
+		return decision, ""
+	}
+	source := d.override.source
+
+	forced := d.override.decision
+	forced.Schedule = decision.Schedule
+	if forced.Mode == strategy.ModeDischarge {
+		floor := d.socLimit
+		if d.socLimitOverride > 0 {
+			floor = d.socLimitOverride
+		}
+		if d.status.USOC <= floor {
+			d.log.Info("battery level reached the limit, forced discharge finished")
+			d.override = nil
+			return strategy.Decision{Mode: strategy.ModeAuto, Reason: strategy.ReasonSocLimit, Schedule: decision.Schedule}, source
+		}
+	}
+	return forced, source
+}
+
+// state returns the current worker state.
+func (d *Discharge) state() State {
+	s := State{
+		Control:     d.control.String(),
+		Discharging: d.control == StateDischarging,
+		Power:       d.power,
+		Schedule:    d.schedule,
+		Paused:      d.paused,
+		SocLimit:    d.socLimitOverride,
+	}
+	if toggler, ok := d.strategy.(strategy.ScheduleToggler); ok {
+		s.Schedules = toggler.ScheduleStates()
+	}
+	if d.override != nil && d.override.decision.Mode == strategy.ModeDischarge {
+		s.Forced = true
+		s.ForcedUntil = d.override.until
+	}
+	return s
+}
+
+func (d *Discharge) publishState() {
+	if d.publisher != nil {
+		d.publisher.PublishState(d.name, d.state())
+	}
+}
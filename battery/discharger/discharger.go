@@ -1,9 +1,17 @@
 package discharger
 
 import (
+	"context"
+	"fmt"
+	"gok-pi/battery/audit"
+	"gok-pi/battery/energy"
 	"gok-pi/battery/entity"
+	"gok-pi/battery/recorder"
+	"gok-pi/battery/strategy"
+	"gok-pi/integrations/notify"
+	"gok-pi/internal/lib/clock"
 	"gok-pi/internal/lib/sl"
-	"gok-pi/internal/lib/timer"
+	"gok-pi/metrics/health"
 	"gok-pi/metrics/observers"
 	"log/slog"
 	"time"
@@ -17,117 +25,334 @@ type Client interface {
 	SwitchOperatingModeToAuto(currentMode string) error
 }
 
+const (
+	historySize         = 60
+	defaultPollInterval = 10 * time.Second
+	// noSchedule labels energy moved while no schedule is in effect.
+	noSchedule = "none"
+)
+
+// Polling controls how often the battery status is requested. In adaptive mode the status
+// is polled with the Fast interval while discharging or within BoundaryMargin of a schedule
+// boundary, and with the Slow interval otherwise.
+type Polling struct {
+	Interval       time.Duration
+	Adaptive       bool
+	Fast           time.Duration
+	Slow           time.Duration
+	BoundaryMargin time.Duration
+}
+
 type Discharge struct {
 	name             string
 	discharge        bool
-	schedules        []entity.Schedule
 	capacityLimit    float64
 	powerLimit       int
 	socLimit         float64
-	readyToDischarge bool
-	isDischarging    bool
+	strategy         strategy.Strategy
+	history          []strategy.Sample
+	control          ControlState
+	power            int
+	polling          Polling
+	schedule         string
+	paused           bool
+	override         *override
+	socLimitOverride float64
+	commands         chan func(now time.Time)
+	meter            energy.Meter
+	energy           *energy.Store
+	recorder         *recorder.Recorder
+	health           *health.Registry
+	observer         observers.Observer
+	publisher        StatePublisher
+	notifier         Notifier
+	alerts           Alerts
+	watchdog         Watchdog
+	alert            alertState
+	auditLog         AuditWriter
+	auditAfter       *audit.Entry
+	trigger          trigger
+	pausedBy         string
 	client           Client
 	status           *entity.SystemStatus
+	clock            clock.Clock
 	log              *slog.Logger
 }
 
-func New(name string, discharge bool, client Client, log *slog.Logger) (*Discharge, error) {
+func New(name string, discharge bool, client Client, strategy strategy.Strategy, log *slog.Logger) (*Discharge, error) {
 	return &Discharge{
 		name:      name,
 		discharge: discharge,
 		client:    client,
+		strategy:  strategy,
+		polling:   Polling{Interval: defaultPollInterval},
+		schedule:  noSchedule,
+		observer:  observers.Nop{},
+		commands:  make(chan func(now time.Time), commandQueueSize),
+		clock:     clock.Real{},
 		log:       log.With(sl.Module("battery.discharge")),
 	}, nil
 }
 
+// SetClock replaces the wall clock, e.g. by a fake clock in tests and simulations.
+func (d *Discharge) SetClock(clock clock.Clock) {
+	d.clock = clock
+}
+
 func (d *Discharge) SetCapacityLimit(capacityLimit int) {
 	d.capacityLimit = float64(capacityLimit)
 }
 
-func (d *Discharge) SetLimits(powerLimit, socLimit int) {
-	d.powerLimit = powerLimit
-	d.socLimit = float64(socLimit)
+func (d *Discharge) SetObserver(observer observers.Observer) {
+	d.observer = observer
 }
 
-func (d *Discharge) AddSchedule(schedule entity.Schedule) {
-	d.schedules = append(d.schedules, schedule)
+// SetEnergyStore enables persisting daily energy totals.
+func (d *Discharge) SetEnergyStore(store *energy.Store) {
+	d.energy = store
 }
 
-func (d *Discharge) Run() error {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+// SetRecorder enables recording every polled status to the local history.
+func (d *Discharge) SetRecorder(recorder *recorder.Recorder) {
+	d.recorder = recorder
+}
+
+// SetHealth registers the worker for readiness checks with its longest polling interval.
+// It is called after SetPolling and SetClock.
+func (d *Discharge) SetHealth(health *health.Registry) {
+	d.health = health
+	if health == nil {
+		return
+	}
+	interval := d.polling.Interval
+	if d.polling.Adaptive {
+		interval = max(d.polling.Fast, d.polling.Slow)
+	}
+	health.Register(d.name, interval, d.clock.Now())
+}
+
+func (d *Discharge) SetPolling(polling Polling) {
+	if polling.Interval <= 0 {
+		polling.Interval = defaultPollInterval
+	}
+	d.polling = polling
+}
+
+// Run polls the battery status and applies the strategy decision until ctx is done. Besides
+// the polling timer, a second timer is armed for the next schedule boundary, so that
+// discharge starts and stops on time regardless of the polling interval. A running
+// discharge is stopped on return, so that the battery is not left in manual mode.
+func (d *Discharge) Run(ctx context.Context) error {
+	d.observer.UpdateControlState(d.name, d.control.String())
+	poll := d.clock.NewTimer(d.pollInterval(d.clock.Now()))
+	defer poll.Stop()
+
+	boundary, boundaryC := d.armBoundary(d.clock.Now())
+	defer func() {
+		if boundary != nil {
+			boundary.Stop()
+		}
+	}()
 
 	for {
 		select {
-		case <-ticker.C:
-			status, err := d.client.Status()
-			if err != nil {
-				d.log.With(sl.Err(err)).Error("checking battery status")
-				continue
+		case <-ctx.Done():
+			if d.control == StateDischarging || d.control == StateError {
+				d.trigger = trigger{reason: "shutting down", source: audit.SourceShutdown}
+				if err := d.stopDischarge("shutting down"); err != nil {
+					return fmt.Errorf("stopping discharge on shutdown: %w", err)
+				}
 			}
-			d.status = status
-			d.observeStatus()
-
-			if !d.discharge {
-				continue
+			return nil
+		case <-poll.C():
+			d.poll()
+			poll.Reset(d.pollInterval(d.clock.Now()))
+		case <-boundaryC:
+			d.log.Debug("schedule boundary reached")
+			d.evaluate(d.clock.Now())
+			boundary, boundaryC = d.armBoundary(d.clock.Now())
+			if !poll.Stop() {
+				<-poll.C()
 			}
-
-			d.checkTime()
-			if d.readyToDischarge {
-				d.runDischarge()
-			} else {
-				err = d.stopDischarge()
-				if err != nil {
-					d.log.With(sl.Err(err)).Error("stopping discharge")
-				}
+			poll.Reset(d.pollInterval(d.clock.Now()))
+		case cmd := <-d.commands:
+			now := d.clock.Now()
+			cmd(now)
+			d.evaluate(now)
+			d.publishState()
+			// commands may enable or disable schedules, moving the next boundary
+			if boundary != nil {
+				boundary.Stop()
 			}
+			boundary, boundaryC = d.armBoundary(now)
+		}
+	}
+}
+
+// armBoundary starts a timer firing at the next strategy boundary. The returned channel is nil
+// if the strategy has no known boundaries, so receiving from it blocks forever.
+func (d *Discharge) armBoundary(now time.Time) (clock.Timer, <-chan time.Time) {
+	b, ok := d.strategy.(strategy.Boundaries)
+	if !ok || !d.discharge {
+		return nil, nil
+	}
+	next, ok := b.NextBoundary(now)
+	if !ok {
+		return nil, nil
+	}
+	d.log.With(slog.Time("at", next)).Debug("next schedule boundary")
+	timer := d.clock.NewTimer(next.Sub(now))
+	return timer, timer.C()
+}
+
+// poll requests the battery status and applies the strategy decision.
+func (d *Discharge) poll() {
+	defer d.checkFailures()
+	status, ok := d.requestStatus()
+	if ok {
+		d.update(d.clock.Now(), status)
+	}
+}
+
+// Step polls the battery once. Together with a fake clock it lets tests and offline
+// replays drive the worker without Run.
+func (d *Discharge) Step() {
+	d.poll()
+}
+
+func (d *Discharge) requestStatus() (*entity.SystemStatus, bool) {
+	status, err := d.client.Status()
+	if err != nil {
+		d.log.With(sl.Err(err)).Error("checking battery status")
+		d.apiFailed(err)
+		if d.health != nil {
+			d.health.PollFailed(d.name, d.clock.Now(), err)
 		}
+		return nil, false
 	}
+	return status, true
 }
 
-// isReadyToDischarge checks if the battery is ready to start discharging based on status and SoC limit.
-func (d *Discharge) isReadyToDischarge() bool {
-	return d.status != nil && d.status.USOC > d.socLimit
+// update processes a polled status received at now.
+func (d *Discharge) update(now time.Time, status *entity.SystemStatus) {
+	d.auditPolled(now, status)
+	d.observer.UpdateLastPoll(d.name, now)
+	if d.health != nil {
+		d.health.PollSucceeded(d.name, now)
+	}
+	d.status = status
+	d.addHistory(now, status)
+	d.observeStatus()
+	d.accountEnergy(now, status)
+	d.record(now, status)
+	if d.publisher != nil {
+		d.publisher.PublishStatus(d.name, status)
+	}
+
+	d.evaluate(now)
+	d.checkManual(now)
+	d.publishState()
 }
 
-// isTimeToDischarge determines whether the current time falls within the specified discharge time window.
-func (d *Discharge) isTimeToDischarge(start, stop string) bool {
-	// Calculate the start and stop times for today
-	startTime, err := timer.ParseTime(start)
+func (d *Discharge) record(now time.Time, status *entity.SystemStatus) {
+	if d.recorder == nil {
+		return
+	}
+	err := d.recorder.Record(d.name, now, status)
 	if err != nil {
-		d.log.With(sl.Err(err)).Error("parsing start time")
-		return false
+		d.log.With(sl.Err(err)).Error("recording status")
 	}
-	stopTime, err := timer.ParseTime(stop)
+}
+
+// accountEnergy integrates the AC power since the previous poll and attributes it to the
+// schedule that was in effect during that interval.
+func (d *Discharge) accountEnergy(now time.Time, status *entity.SystemStatus) {
+	discharged, charged := d.meter.Add(now, status.PacTotalW)
+	if discharged == 0 && charged == 0 {
+		return
+	}
+	d.observer.AddEnergy(d.name, d.schedule, discharged, charged)
+	if d.energy == nil {
+		return
+	}
+	err := d.energy.Add(now, d.name, d.schedule, discharged, charged)
 	if err != nil {
-		d.log.With(sl.Err(err)).Error("parsing stop time")
-		return false
+		d.log.With(sl.Err(err)).Error("saving energy totals")
 	}
-	if startTime.After(stopTime) {
-		stopTime = stopTime.Add(24 * time.Hour)
+}
+
+// evaluate applies the strategy decision for the last known status. At schedule boundaries
+// it runs without requesting a fresh status, so API retries cannot delay the transition.
+// While paused no control actions are taken once the battery is back in automatic mode.
+func (d *Discharge) evaluate(now time.Time) {
+	if !d.discharge || d.status == nil {
+		return
+	}
+	if d.paused {
+		d.enterPause()
+		return
 	}
-	now := time.Now()
-	return now.After(startTime) && now.Before(stopTime)
+
+	decision, source := d.decide(now)
+	d.trigger = triggerOf(decision, source)
+	schedule := noSchedule
+	if decision.Schedule != nil {
+		schedule = decision.Schedule.Label()
+	}
+	if schedule != d.schedule {
+		d.log.With(slog.String("schedule", schedule)).Info("active schedule changed")
+		d.schedule = schedule
+	}
+	d.observer.UpdateActiveSchedule(d.name, d.schedule)
+	d.apply(decision)
 }
 
-// checkTime determines whether the current time falls within the specified discharge time window.
-func (d *Discharge) checkTime() {
+// pollInterval returns the delay before the next status request.
+func (d *Discharge) pollInterval(now time.Time) time.Duration {
+	if !d.polling.Adaptive {
+		return d.polling.Interval
+	}
+	if d.control == StateDischarging {
+		return d.polling.Fast
+	}
+	if b, ok := d.strategy.(strategy.Boundaries); ok && d.discharge {
+		next, ok := b.NextBoundary(now)
+		if ok && next.Sub(now) <= d.polling.BoundaryMargin {
+			return d.polling.Fast
+		}
+	}
+	return d.polling.Slow
+}
 
-	for _, schedule := range d.schedules {
-		if schedule.Enabled {
-			if d.isTimeToDischarge(schedule.StartTime, schedule.StopTime) {
-				d.SetLimits(schedule.PowerLimit, schedule.SocLimit)
-				d.readyToDischarge = true
-				return
+// addHistory appends the sample to the history, keeping at most historySize samples.
+func (d *Discharge) addHistory(t time.Time, status *entity.SystemStatus) {
+	d.history = append(d.history, strategy.Sample{Time: t, Status: status})
+	if len(d.history) > historySize {
+		d.history = d.history[len(d.history)-historySize:]
+	}
+}
+
+// apply brings the battery to the state requested by the strategy.
+func (d *Discharge) apply(decision strategy.Decision) {
+	switch decision.Mode {
+	case strategy.ModeDischarge:
+		d.runDischarge(decision.Power)
+	default:
+		if d.control == StateDischarging {
+			d.log.With(slog.String("reason", decision.Reason)).Info("stopping discharge")
+			if decision.Reason == strategy.ReasonSocLimit && d.status != nil {
+				d.notify(notify.KindSocFloor, "SoC limit reached",
+					fmt.Sprintf("discharge stopped at %.0f%% SoC", d.status.USOC))
 			}
 		}
+		_ = d.stopDischarge(decision.Reason)
 	}
-
-	d.readyToDischarge = false
 }
 
-// runDischarge manages the discharge process of the battery based on its current status and predefined limits.
-func (d *Discharge) runDischarge() {
+// runDischarge starts discharging with the given power, or updates the setpoint if the battery
+// is already discharging with a different one. If the discharge cannot be started after the
+// switch to manual mode, automatic mode is restored.
+func (d *Discharge) runDischarge(power int) {
 	if d.status == nil {
 		return
 	}
@@ -137,55 +362,112 @@ func (d *Discharge) runDischarge() {
 		slog.Float64("SoC", d.status.RSOC),
 		slog.Float64("consumption", d.status.ConsumptionW),
 		slog.Bool("discharge", d.status.BatteryDischarging),
+		slog.Int("power", power),
 	)
 
-	if d.isDischarging {
-		if !d.isReadyToDischarge() {
-			log.Info("battery level reached the limit, stopping discharge")
-			err := d.stopDischarge()
-			if err != nil {
-				d.log.With(sl.Err(err)).Error("stopping discharge")
-				return
-			}
+	if d.control == StateDischarging {
+		if d.power == power {
+			return
+		}
+		log.Info("changing discharge power")
+		err := d.client.StartDischarge(power)
+		d.observer.IncDischargeCommand(d.name, "power", err)
+		d.audit(audit.ActionPower, power, "", err)
+		if err != nil {
+			d.log.With(sl.Err(err)).Error("changing discharge power")
+			d.apiFailed(err)
+			return
 		}
+		d.power = power
 		return
 	}
 
+	from := d.control
+	d.transition(StateSwitchingToManual, "discharge requested")
 	err := d.client.SwitchOperatingModeToManual(d.status.OperatingMode)
+	d.observer.IncModeSwitch(d.name, "manual", err)
+	d.audit(audit.ActionManual, 0, "", err)
 	if err != nil {
 		d.log.With(sl.Err(err)).Error("switching operating mode")
+		d.apiFailed(err)
+		d.transition(from, "switching to manual mode failed")
 		return
 	}
+	d.modeSwitched(operatingModeManual)
 
 	log.Info("starting discharge")
-	err = d.client.StartDischarge(d.powerLimit)
+	err = d.client.StartDischarge(power)
+	d.observer.IncDischargeCommand(d.name, "start", err)
+	d.audit(audit.ActionStart, power, "", err)
 	if err != nil {
 		d.log.With(sl.Err(err)).Error("starting discharge")
+		d.apiFailed(err)
+		d.rollback()
 		return
 	}
-	d.isDischarging = true
+	d.power = power
+	d.transition(StateDischarging, "discharge started")
+}
 
+// modeSwitched records a successful operating mode switch in the last polled status, so
+// that commands and boundaries before the next poll do not rely on the outdated mode.
+func (d *Discharge) modeSwitched(mode string) {
+	if d.status != nil {
+		d.status.OperatingMode = mode
+	}
 }
 
-// stopDischarge stops the current discharge activity if it is ongoing.
-// Returns an error if the operation fails at any point.
-func (d *Discharge) stopDischarge() error {
-	if d.isDischarging {
+// rollback restores automatic mode after a discharge could not be started, so that the
+// battery is not left in manual mode without a setpoint.
+func (d *Discharge) rollback() {
+	// the battery was just switched to manual mode, the polled mode is outdated
+	err := d.client.SwitchOperatingModeToAuto(operatingModeManual)
+	d.observer.IncModeSwitch(d.name, "auto", err)
+	d.audit(audit.ActionAuto, 0, "rollback after failed start", err)
+	if err != nil {
+		d.log.With(sl.Err(err)).Error("rolling back to automatic mode")
+		d.apiFailed(err)
+		d.transition(StateError, "rollback to automatic mode failed")
+		return
+	}
+	d.modeSwitched(operatingModeAuto)
+	d.transition(StateIdle, "discharge start failed, rolled back to automatic mode")
+}
 
-		err := d.client.StopDischarge()
-		if err != nil {
-			return err
-		}
+// stopDischarge stops a discharge, or restores automatic mode after an error, and returns
+// to Idle. If the stop command fails the battery is still discharging; if only the switch
+// to automatic mode fails the worker enters Error. Both are retried on the next evaluation.
+func (d *Discharge) stopDischarge(reason string) error {
+	from := d.control
+	if from != StateDischarging && from != StateError {
+		return nil
+	}
+	d.transition(StateStopping, reason)
 
-		if d.status != nil {
-			err = d.client.SwitchOperatingModeToAuto(d.status.OperatingMode)
-			if err != nil {
-				return err
-			}
-		}
+	err := d.client.StopDischarge()
+	d.observer.IncDischargeCommand(d.name, "stop", err)
+	d.audit(audit.ActionStop, 0, "", err)
+	if err != nil {
+		d.log.With(sl.Err(err)).Error("stopping discharge")
+		d.apiFailed(err)
+		d.transition(from, "stop command failed")
+		return err
+	}
+	d.power = 0
 
-		d.isDischarging = false
+	// the battery was switched to manual by this worker, possibly after the last poll,
+	// so the polled mode must not be used to skip the request
+	err = d.client.SwitchOperatingModeToAuto(operatingModeManual)
+	d.observer.IncModeSwitch(d.name, "auto", err)
+	d.audit(audit.ActionAuto, 0, "", err)
+	if err != nil {
+		d.log.With(sl.Err(err)).Error("switching operating mode")
+		d.apiFailed(err)
+		d.transition(StateError, "switching to automatic mode failed")
+		return err
 	}
+	d.modeSwitched(operatingModeAuto)
+	d.transition(StateIdle, "discharge stopped")
 	return nil
 }
 
@@ -206,19 +488,11 @@ func (d *Discharge) stopDischarge() error {
 //	return int(rate)
 //}
 
-// observeStatus updates various battery status metrics through external observers.
+// observeStatus updates the battery status metrics.
 // If the status is nil, the method returns immediately.
 func (d *Discharge) observeStatus() {
 	if d.status == nil {
 		return
 	}
-	go func(status *entity.SystemStatus) {
-		observers.UpdateSoC(d.name, status.RSOC)
-		observers.UpdateUSoC(d.name, status.USOC)
-		observers.UpdateCapacity(d.name, status.RemainingCapacityWh)
-		observers.UpdateConsumption(d.name, status.ConsumptionW)
-		observers.UpdatePac(d.name, status.PacTotalW)
-		observers.UpdateDischargeState(d.name, status.BatteryDischarging)
-		observers.UpdateOpMode(d.name, status.OperatingMode)
-	}(d.status)
+	d.observer.UpdateStatus(d.name, d.status)
 }
@@ -0,0 +1,628 @@
+package discharger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gok-pi/battery/audit"
+	"gok-pi/battery/entity"
+	"gok-pi/battery/strategy"
+	"gok-pi/internal/lib/clock"
+	"io"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errApi = errors.New("api unavailable")
+
+// fakeClient records the control calls in order. Errors are returned for the calls
+// listed in fail, each entry failing once.
+type fakeClient struct {
+	mutex  sync.Mutex
+	status entity.SystemStatus
+	fail   map[string]int
+	calls  []string
+}
+
+func newFakeClient(usoc float64) *fakeClient {
+	return &fakeClient{
+		status: entity.SystemStatus{USOC: usoc, RSOC: usoc, OperatingMode: "2"},
+		fail:   make(map[string]int),
+	}
+}
+
+func (c *fakeClient) call(name string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls = append(c.calls, name)
+	if c.fail[name] > 0 {
+		c.fail[name]--
+		return errApi
+	}
+	switch name {
+	case "manual":
+		c.status.OperatingMode = "1"
+	case "auto":
+		c.status.OperatingMode = "2"
+	}
+	return nil
+}
+
+func (c *fakeClient) Status() (*entity.SystemStatus, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.fail["status"] > 0 {
+		c.fail["status"]--
+		return nil, errApi
+	}
+	s := c.status
+	return &s, nil
+}
+
+func (c *fakeClient) StartDischarge(power int) error {
+	return c.call(fmt.Sprintf("start %d", power))
+}
+
+func (c *fakeClient) StopDischarge() error {
+	return c.call("stop")
+}
+
+// SwitchOperatingModeToManual skips the call if currentMode is already manual, like
+// the real client does.
+func (c *fakeClient) SwitchOperatingModeToManual(currentMode string) error {
+	if currentMode == "1" {
+		return nil
+	}
+	return c.call("manual")
+}
+
+// SwitchOperatingModeToAuto skips the call if currentMode is already automatic, like
+// the real client does.
+func (c *fakeClient) SwitchOperatingModeToAuto(currentMode string) error {
+	if currentMode == "2" {
+		return nil
+	}
+	return c.call("auto")
+}
+
+func (c *fakeClient) mode() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.status.OperatingMode
+}
+
+func (c *fakeClient) setSoc(usoc float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.status.USOC = usoc
+	c.status.RSOC = usoc
+}
+
+// takeCalls returns and clears the recorded calls.
+func (c *fakeClient) takeCalls() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	calls := c.calls
+	c.calls = nil
+	return calls
+}
+
+func (c *fakeClient) peekCalls() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+var day = time.Date(2024, 8, 14, 0, 0, 0, 0, time.Local)
+
+// at returns the given "15:04" time on day, or on the next day with a "+" prefix.
+func at(t *testing.T, s string) time.Time {
+	t.Helper()
+	d := day
+	if s[0] == '+' {
+		d = d.AddDate(0, 0, 1)
+		s = s[1:]
+	}
+	c, err := time.Parse("15:04", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.Add(time.Duration(c.Hour())*time.Hour + time.Duration(c.Minute())*time.Minute)
+}
+
+func newWorker(t *testing.T, client Client, clk clock.Clock, schedules ...entity.Schedule) *Discharge {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	d, err := New("b1", true, client, strategy.NewFixedWindow(schedules, nil, log), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetLimits(1000, 20)
+	d.SetClock(clk)
+	return d
+}
+
+func schedule(start, stop string, power, soc int) entity.Schedule {
+	return entity.Schedule{
+		StartTime:   start,
+		StopTime:    stop,
+		BatteryName: "b1",
+		Enabled:     true,
+		PowerLimit:  power,
+		SocLimit:    soc,
+	}
+}
+
+// step is one poll at the given time with the battery at usoc percent.
+type step struct {
+	at    string
+	usoc  float64
+	fail  []string
+	calls []string
+	state ControlState
+}
+
+func TestDischargeSteps(t *testing.T) {
+	tests := []struct {
+		name      string
+		schedules []entity.Schedule
+		steps     []step
+	}{
+		{
+			name:      "schedule entry and exit",
+			schedules: []entity.Schedule{schedule("20:00", "21:00", 800, 30)},
+			steps: []step{
+				{at: "19:59", usoc: 60},
+				{at: "20:00", usoc: 60, calls: []string{"manual", "start 800"}, state: StateDischarging},
+				{at: "20:30", usoc: 50, state: StateDischarging},
+				{at: "21:00", usoc: 45, calls: []string{"stop", "auto"}},
+				{at: "21:30", usoc: 45},
+			},
+		},
+		{
+			name:      "overnight window",
+			schedules: []entity.Schedule{schedule("22:00", "02:00", 500, 30)},
+			steps: []step{
+				{at: "21:59", usoc: 80},
+				{at: "23:30", usoc: 80, calls: []string{"manual", "start 500"}, state: StateDischarging},
+				{at: "+00:30", usoc: 70, state: StateDischarging},
+				{at: "+01:59", usoc: 60, state: StateDischarging},
+				{at: "+02:00", usoc: 60, calls: []string{"stop", "auto"}},
+			},
+		},
+		{
+			name:      "start after midnight within overnight window",
+			schedules: []entity.Schedule{schedule("22:00", "02:00", 500, 30)},
+			steps: []step{
+				{at: "+00:30", usoc: 80, calls: []string{"manual", "start 500"}, state: StateDischarging},
+				{at: "+02:00", usoc: 70, calls: []string{"stop", "auto"}},
+			},
+		},
+		{
+			name:      "soc limit reached mid discharge",
+			schedules: []entity.Schedule{schedule("20:00", "23:00", 800, 30)},
+			steps: []step{
+				{at: "20:00", usoc: 40, calls: []string{"manual", "start 800"}, state: StateDischarging},
+				{at: "21:00", usoc: 31, state: StateDischarging},
+				{at: "21:10", usoc: 30, calls: []string{"stop", "auto"}},
+				{at: "21:20", usoc: 30},
+			},
+		},
+		{
+			name:      "soc below limit at schedule start",
+			schedules: []entity.Schedule{schedule("20:00", "23:00", 800, 30)},
+			steps: []step{
+				{at: "20:00", usoc: 25},
+				{at: "21:00", usoc: 25},
+			},
+		},
+		{
+			name:      "higher priority overlapping schedule changes power",
+			schedules: []entity.Schedule{schedule("20:00", "23:00", 500, 30), {StartTime: "21:00", StopTime: "22:00", BatteryName: "b1", Enabled: true, PowerLimit: 900, SocLimit: 30, Priority: 1}},
+			steps: []step{
+				{at: "20:00", usoc: 80, calls: []string{"manual", "start 500"}, state: StateDischarging},
+				{at: "21:00", usoc: 70, calls: []string{"start 900"}, state: StateDischarging},
+				{at: "22:00", usoc: 60, calls: []string{"start 500"}, state: StateDischarging},
+				{at: "23:00", usoc: 50, calls: []string{"stop", "auto"}},
+			},
+		},
+		{
+			name:      "manual mode switch fails",
+			schedules: []entity.Schedule{schedule("20:00", "21:00", 800, 30)},
+			steps: []step{
+				{at: "20:00", usoc: 60, fail: []string{"manual"}, calls: []string{"manual"}},
+				{at: "20:01", usoc: 60, calls: []string{"manual", "start 800"}, state: StateDischarging},
+			},
+		},
+		{
+			name:      "start fails and is retried",
+			schedules: []entity.Schedule{schedule("20:00", "21:00", 800, 30)},
+			steps: []step{
+				{at: "20:00", usoc: 60, fail: []string{"start 800"}, calls: []string{"manual", "start 800", "auto"}},
+				{at: "20:01", usoc: 60, calls: []string{"manual", "start 800"}, state: StateDischarging},
+			},
+		},
+		{
+			name:      "rollback after failed start fails",
+			schedules: []entity.Schedule{schedule("20:00", "21:00", 800, 30)},
+			steps: []step{
+				{at: "20:00", usoc: 60, fail: []string{"start 800", "auto"}, calls: []string{"manual", "start 800", "auto"}, state: StateError},
+				{at: "20:01", usoc: 60, calls: []string{"start 800"}, state: StateDischarging},
+			},
+		},
+		{
+			name:      "error outside schedule restores automatic mode",
+			schedules: []entity.Schedule{schedule("20:00", "21:00", 800, 30)},
+			steps: []step{
+				{at: "20:59", usoc: 60, fail: []string{"start 800", "auto"}, calls: []string{"manual", "start 800", "auto"}, state: StateError},
+				{at: "21:00", usoc: 60, calls: []string{"stop", "auto"}},
+			},
+		},
+		{
+			name:      "stop fails and is retried",
+			schedules: []entity.Schedule{schedule("20:00", "21:00", 800, 30)},
+			steps: []step{
+				{at: "20:00", usoc: 60, calls: []string{"manual", "start 800"}, state: StateDischarging},
+				{at: "21:00", usoc: 50, fail: []string{"stop"}, calls: []string{"stop"}, state: StateDischarging},
+				{at: "21:01", usoc: 50, calls: []string{"stop", "auto"}},
+			},
+		},
+		{
+			name:      "auto mode switch fails and is retried",
+			schedules: []entity.Schedule{schedule("20:00", "21:00", 800, 30)},
+			steps: []step{
+				{at: "20:00", usoc: 60, calls: []string{"manual", "start 800"}, state: StateDischarging},
+				{at: "21:00", usoc: 50, fail: []string{"auto"}, calls: []string{"stop", "auto"}, state: StateError},
+				{at: "21:01", usoc: 50, calls: []string{"stop", "auto"}},
+			},
+		},
+		{
+			name:      "status failure takes no action",
+			schedules: []entity.Schedule{schedule("20:00", "21:00", 800, 30)},
+			steps: []step{
+				{at: "20:00", usoc: 60, fail: []string{"status"}},
+				{at: "20:01", usoc: 60, calls: []string{"manual", "start 800"}, state: StateDischarging},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(0)
+			clk := clock.NewFake(at(t, tt.steps[0].at))
+			d := newWorker(t, client, clk, tt.schedules...)
+
+			for _, s := range tt.steps {
+				clk.Set(at(t, s.at))
+				client.setSoc(s.usoc)
+				for _, call := range s.fail {
+					client.fail[call]++
+				}
+				d.Step()
+
+				calls := client.takeCalls()
+				if !reflect.DeepEqual(calls, s.calls) {
+					t.Errorf("%s: calls = %q, want %q", s.at, calls, s.calls)
+				}
+				if d.ControlState() != s.state {
+					t.Errorf("%s: state = %s, want %s", s.at, d.ControlState(), s.state)
+				}
+			}
+		})
+	}
+}
+
+// runCommands executes the queued commands like Run does.
+func runCommands(d *Discharge) {
+	for {
+		select {
+		case cmd := <-d.commands:
+			now := d.clock.Now()
+			cmd(now)
+			d.evaluate(now)
+		default:
+			return
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	client := newFakeClient(80)
+	clk := clock.NewFake(at(t, "20:00"))
+	d := newWorker(t, client, clk, schedule("20:00", "22:00", 800, 30))
+
+	d.Step()
+	client.takeCalls()
+
+	// the stop fails on pause and on the evaluation following the command,
+	// the battery keeps discharging until the retry on the next poll succeeds
+	client.fail["stop"] += 2
+	if err := d.Pause("test", true); err != nil {
+		t.Fatal(err)
+	}
+	runCommands(d)
+	if d.ControlState() != StateDischarging {
+		t.Errorf("state = %s, want %s", d.ControlState(), StateDischarging)
+	}
+
+	clk.Set(at(t, "20:10"))
+	d.Step()
+	if d.ControlState() != StatePaused {
+		t.Errorf("state = %s, want %s", d.ControlState(), StatePaused)
+	}
+	want := []string{"stop", "stop", "stop", "auto"}
+	if calls := client.takeCalls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	// no control while paused, even within the schedule
+	clk.Set(at(t, "20:20"))
+	d.Step()
+	if calls := client.takeCalls(); len(calls) > 0 {
+		t.Errorf("calls while paused = %q, want none", calls)
+	}
+
+	if err := d.Pause("test", false); err != nil {
+		t.Fatal(err)
+	}
+	runCommands(d)
+	if d.ControlState() != StateDischarging {
+		t.Errorf("state = %s, want %s", d.ControlState(), StateDischarging)
+	}
+	want = []string{"manual", "start 800"}
+	if calls := client.takeCalls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+// TestForceDischarge checks that a forced discharge is capped at the power limit and
+// stops at the SoC limit.
+func TestForceDischarge(t *testing.T) {
+	client := newFakeClient(22)
+	clk := clock.NewFake(at(t, "12:00"))
+	d := newWorker(t, client, clk)
+	d.Step()
+
+	if err := d.ForceDischarge("test", 2500, 0); err != nil {
+		t.Fatal(err)
+	}
+	runCommands(d)
+	want := []string{"manual", "start 1000"}
+	if calls := client.takeCalls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	client.setSoc(20)
+	clk.Set(at(t, "12:10"))
+	d.Step()
+	want = []string{"stop", "auto"}
+	if calls := client.takeCalls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls at the SoC limit = %q, want %q", calls, want)
+	}
+}
+
+// TestForceDischargeWithoutLimits checks that a forced discharge is refused if the
+// battery has no limits instead of discharging uncapped down to 0%.
+func TestForceDischargeWithoutLimits(t *testing.T) {
+	for _, limits := range [][2]int{{0, 0}, {1000, 0}, {0, 20}} {
+		client := newFakeClient(80)
+		clk := clock.NewFake(at(t, "12:00"))
+		d := newWorker(t, client, clk)
+		d.SetLimits(limits[0], limits[1])
+		d.Step()
+
+		if err := d.ForceDischarge("test", 800, time.Hour); !errors.Is(err, ErrNoLimits) {
+			t.Errorf("limits %v: error = %v, want %v", limits, err, ErrNoLimits)
+		}
+		runCommands(d)
+		if calls := client.takeCalls(); len(calls) > 0 {
+			t.Errorf("limits %v: calls = %q, want none", limits, calls)
+		}
+	}
+}
+
+// TestRestartBeforePoll checks that a discharge restarted before the next poll switches
+// to manual mode again although the last polled status reported manual mode.
+func TestRestartBeforePoll(t *testing.T) {
+	client := newFakeClient(80)
+	clk := clock.NewFake(at(t, "20:00"))
+	d := newWorker(t, client, clk, schedule("20:00", "22:00", 800, 30))
+
+	d.Step()
+	clk.Set(at(t, "20:01"))
+	d.Step()
+	client.takeCalls()
+
+	for _, paused := range []bool{true, false} {
+		if err := d.Pause("test", paused); err != nil {
+			t.Fatal(err)
+		}
+		runCommands(d)
+	}
+	want := []string{"stop", "auto", "manual", "start 800"}
+	if calls := client.takeCalls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if mode := client.mode(); mode != "1" {
+		t.Errorf("operating mode = %q, want manual", mode)
+	}
+}
+
+func TestTransitionsAreDefined(t *testing.T) {
+	for from, targets := range transitions {
+		for _, to := range targets {
+			if from == to {
+				t.Errorf("%s: self transition", from)
+			}
+			if to.String() == "unknown" {
+				t.Errorf("%s: transition to unnamed state %d", from, to)
+			}
+		}
+	}
+	for state := range stateNames {
+		if _, ok := transitions[state]; !ok {
+			t.Errorf("%s: no transitions defined", state)
+		}
+	}
+}
+
+func TestDischargeDisabledTakesNoAction(t *testing.T) {
+	client := newFakeClient(80)
+	clk := clock.NewFake(at(t, "20:30"))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	d, err := New("b1", false, client, strategy.NewFixedWindow([]entity.Schedule{schedule("20:00", "21:00", 800, 30)}, nil, log), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetClock(clk)
+
+	d.Step()
+	if calls := client.takeCalls(); len(calls) > 0 {
+		t.Errorf("calls = %q, want none", calls)
+	}
+}
+
+// TestRunStartsAtBoundary checks that Run starts and stops the discharge at the schedule
+// boundaries even though the next poll is due much later.
+func TestRunStartsAtBoundary(t *testing.T) {
+	client := newFakeClient(80)
+	clk := clock.NewFake(at(t, "19:00"))
+	d := newWorker(t, client, clk, schedule("20:00", "20:30", 800, 30))
+	d.SetPolling(Polling{Interval: 45 * time.Minute})
+	go func() {
+		_ = d.Run(context.Background())
+	}()
+
+	// poll timer and boundary timer
+	waitFor(t, "timers armed", func() bool { return clk.Timers() == 2 })
+	clk.Set(at(t, "19:45"))
+	waitFor(t, "poll", func() bool { return clk.Timers() == 2 })
+
+	clk.Set(at(t, "20:00"))
+	waitFor(t, "discharge started", func() bool { return len(client.peekCalls()) == 2 })
+	waitFor(t, "timers re-armed", func() bool { return clk.Timers() == 2 })
+
+	clk.Set(at(t, "20:30"))
+	waitFor(t, "discharge stopped", func() bool { return len(client.peekCalls()) == 4 })
+
+	want := []string{"manual", "start 800", "stop", "auto"}
+	if calls := client.takeCalls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if mode := client.mode(); mode != "2" {
+		t.Errorf("operating mode = %q after the boundary stop, want automatic", mode)
+	}
+}
+
+// TestRunArmsBoundaryOfEnabledSchedule checks that a schedule enabled at runtime starts
+// on time although no schedule was enabled when Run armed its timers.
+func TestRunArmsBoundaryOfEnabledSchedule(t *testing.T) {
+	client := newFakeClient(80)
+	clk := clock.NewFake(at(t, "19:00"))
+	s := schedule("20:00", "20:30", 800, 30)
+	s.Enabled = false
+	d := newWorker(t, client, clk, s)
+	d.SetPolling(Polling{Interval: 45 * time.Minute})
+	go func() {
+		_ = d.Run(context.Background())
+	}()
+
+	waitFor(t, "poll timer armed", func() bool { return clk.Timers() == 1 })
+	if err := d.EnableSchedule("test", s.Label(), true); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "boundary timer armed", func() bool { return clk.Timers() == 2 })
+
+	clk.Set(at(t, "20:00"))
+	waitFor(t, "discharge started", func() bool { return len(client.peekCalls()) == 2 })
+	want := []string{"manual", "start 800"}
+	if calls := client.takeCalls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestRunStopsDischargeOnShutdown(t *testing.T) {
+	client := newFakeClient(80)
+	clk := clock.NewFake(at(t, "20:00"))
+	d := newWorker(t, client, clk, schedule("20:00", "21:00", 800, 30))
+	d.Step()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- d.Run(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	want := []string{"manual", "start 800", "stop", "auto"}
+	if calls := client.takeCalls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if d.ControlState() != StateIdle {
+		t.Errorf("state = %s, want %s", d.ControlState(), StateIdle)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type auditRecorder struct {
+	entries []audit.Entry
+}
+
+func (r *auditRecorder) Write(e audit.Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func TestAuditRecordsActions(t *testing.T) {
+	client := newFakeClient(80)
+	clk := clock.NewFake(at(t, "20:30"))
+	d := newWorker(t, client, clk, schedule("20:00", "21:00", 800, 30))
+	recorder := &auditRecorder{}
+	d.SetAudit(recorder)
+
+	d.Step()
+	if len(recorder.entries) != 2 {
+		t.Fatalf("entries = %+v, want manual and start", recorder.entries)
+	}
+	e := recorder.entries[1]
+	if e.Action != audit.ActionStart || e.Power != 800 || e.Source != audit.SourceSchedule ||
+		e.Schedule != "20:00-21:00" || e.SocBefore != 80 || e.SocAfter != nil || e.Result != "ok" {
+		t.Errorf("start entry = %+v", e)
+	}
+
+	client.setSoc(75)
+	clk.Set(at(t, "20:31"))
+	d.Step()
+	clk.Set(at(t, "20:32"))
+	d.Step()
+
+	if len(recorder.entries) != 3 {
+		t.Fatalf("entries = %+v, want a single polled entry", recorder.entries)
+	}
+	e = recorder.entries[2]
+	if e.Action != audit.ActionPolled || e.Source != audit.SourceSchedule ||
+		e.SocBefore != 80 || e.SocAfter == nil || *e.SocAfter != 75 || !e.Time.Equal(at(t, "20:31")) {
+		t.Errorf("polled entry = %+v", e)
+	}
+}
@@ -0,0 +1,105 @@
+package discharger
+
+import (
+	"log/slog"
+)
+
+// ControlState is the state of the worker's control of the battery.
+//
+//	Idle ──> SwitchingToManual ──> Discharging ──> Stopping ──> Idle
+//	  │              │                  ^              │
+//	  │              └──> Idle, Error   └──────────────┤ stop failed
+//	  │                                                └──> Error (automatic mode not restored)
+//	  └──> Paused ──> Idle
+//
+// Error means the battery may be left in manual mode; the next evaluation either restores
+// automatic mode through Stopping or starts a discharge through SwitchingToManual.
+type ControlState int
+
+const (
+	StateIdle ControlState = iota
+	StateSwitchingToManual
+	StateDischarging
+	StateStopping
+	StateError
+	StatePaused
+)
+
+var stateNames = map[ControlState]string{
+	StateIdle:              "idle",
+	StateSwitchingToManual: "switching_to_manual",
+	StateDischarging:       "discharging",
+	StateStopping:          "stopping",
+	StateError:             "error",
+	StatePaused:            "paused",
+}
+
+func (s ControlState) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// transitions lists the states reachable from each state.
+var transitions = map[ControlState][]ControlState{
+	StateIdle:              {StateSwitchingToManual, StatePaused},
+	StateSwitchingToManual: {StateDischarging, StateIdle, StateError},
+	StateDischarging:       {StateStopping},
+	StateStopping:          {StateIdle, StateDischarging, StateError},
+	StateError:             {StateSwitchingToManual, StateStopping},
+	StatePaused:            {StateIdle},
+}
+
+// canTransition reports whether the state machine defines the transition.
+func (s ControlState) canTransition(to ControlState) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ControlState returns the current control state. It must only be called from the
+// worker goroutine, e.g. in tests driving the worker with Step.
+func (d *Discharge) ControlState() ControlState {
+	return d.control
+}
+
+// transition moves the worker to the given state, logging and exporting the change.
+// Undefined transitions indicate a bug; they are logged but performed anyway, so that
+// the state keeps matching the battery.
+func (d *Discharge) transition(to ControlState, reason string) {
+	from := d.control
+	if from == to {
+		return
+	}
+	log := d.log.With(
+		slog.String("from", from.String()),
+		slog.String("to", to.String()),
+		slog.String("reason", reason),
+	)
+	if !from.canTransition(to) {
+		log.Error("undefined state transition")
+	}
+	d.control = to
+	log.Info("state transition")
+	d.observer.IncStateTransition(d.name, from.String(), to.String())
+	d.observer.UpdateControlState(d.name, to.String())
+}
+
+// enterPause stops a discharge, or restores automatic mode after an error, and suspends
+// control. If stopping fails, it is retried on the next evaluation.
+func (d *Discharge) enterPause() {
+	switch d.control {
+	case StatePaused:
+		return
+	case StateDischarging, StateError:
+		d.trigger = trigger{reason: "paused", source: d.pausedBy}
+		if err := d.stopDischarge("paused"); err != nil {
+			return
+		}
+	}
+	d.transition(StatePaused, "paused")
+}
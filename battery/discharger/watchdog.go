@@ -0,0 +1,84 @@
+package discharger
+
+import (
+	"fmt"
+	"gok-pi/battery/audit"
+	"gok-pi/integrations/notify"
+	"gok-pi/internal/lib/sl"
+	"log/slog"
+	"time"
+)
+
+// EM_OperatingMode values reported while the battery follows a manual setpoint
+// and while it controls itself.
+const (
+	operatingModeManual = "1"
+	operatingModeAuto   = "2"
+)
+
+// Watchdog restores automatic mode when the battery stays in manual mode for GracePeriod
+// outside any schedule without being discharged by this worker, e.g. after a crash
+// mid-discharge or a failed switch back to automatic mode.
+type Watchdog struct {
+	Enabled     bool
+	GracePeriod time.Duration
+}
+
+func (d *Discharge) SetWatchdog(watchdog Watchdog) {
+	d.watchdog = watchdog
+}
+
+// checkManual runs on every poll. A battery in manual mode is considered stuck if no
+// schedule is in effect and the worker does not control it, i.e. is idle or paused. The watchdog
+// only acts if control is enabled and not paused; otherwise an alert is raised after
+// the configured delay.
+func (d *Discharge) checkManual(now time.Time) {
+	stuck := d.status != nil && d.status.OperatingMode == operatingModeManual &&
+		(d.control == StateIdle || d.control == StatePaused) && d.schedule == noSchedule
+	d.observer.UpdateStuckManual(d.name, stuck)
+	if !stuck {
+		d.alert.manualSince = time.Time{}
+		d.alert.manualAlerted = false
+		return
+	}
+	if d.alert.manualSince.IsZero() {
+		d.alert.manualSince = now
+		d.log.Warn("battery in manual mode outside of any schedule")
+	}
+
+	elapsed := now.Sub(d.alert.manualSince)
+	if d.watchdog.Enabled && d.discharge && !d.paused && elapsed >= d.watchdog.GracePeriod {
+		d.restoreAuto()
+		return
+	}
+
+	if d.alert.manualAlerted || d.alerts.StuckManualAfter <= 0 || elapsed < d.alerts.StuckManualAfter {
+		return
+	}
+	d.alert.manualAlerted = true
+	d.notify(notify.KindStuckManual, "battery stuck in manual mode",
+		fmt.Sprintf("operating mode is manual since %s outside of any discharge by gok-pi",
+			d.alert.manualSince.Format(time.DateTime)))
+}
+
+// restoreAuto switches a stuck battery back to automatic mode. On failure it is retried
+// on the next poll.
+func (d *Discharge) restoreAuto() {
+	since := d.alert.manualSince
+	err := d.client.SwitchOperatingModeToAuto(d.status.OperatingMode)
+	d.observer.IncWatchdogRecovery(d.name, err)
+	d.trigger = trigger{reason: "stuck in manual mode", source: audit.SourceWatchdog}
+	d.audit(audit.ActionAuto, 0, "", err)
+	if err != nil {
+		d.log.With(sl.Err(err)).Error("watchdog switching to automatic mode")
+		d.apiFailed(err)
+		return
+	}
+	d.modeSwitched(operatingModeAuto)
+	d.log.With(slog.Time("manual_since", since)).Warn("watchdog restored automatic mode")
+	d.notify(notify.KindWatchdog, "automatic mode restored",
+		fmt.Sprintf("battery was in manual mode since %s outside of any schedule, switched back to automatic mode",
+			since.Format(time.DateTime)))
+	d.alert.manualSince = time.Time{}
+	d.alert.manualAlerted = false
+}
@@ -0,0 +1,46 @@
+package energy
+
+import "time"
+
+// maxGap is the longest interval between two samples that is still integrated.
+// Longer gaps (controller unreachable, service stopped) are skipped rather than guessed.
+const maxGap = 5 * time.Minute
+
+// Meter integrates AC power samples into energy using the trapezoidal rule.
+type Meter struct {
+	last      time.Time
+	lastPower float64
+}
+
+// Add records a power sample in W, positive when discharging and negative when charging,
+// and returns the energy in Wh discharged and charged since the previous sample.
+func (m *Meter) Add(t time.Time, power float64) (discharged, charged float64) {
+	prev, prevPower := m.last, m.lastPower
+	m.last, m.lastPower = t, power
+
+	if prev.IsZero() {
+		return 0, 0
+	}
+	dt := t.Sub(prev)
+	if dt <= 0 || dt > maxGap {
+		return 0, 0
+	}
+	hours := dt.Hours()
+
+	if (prevPower >= 0) == (power >= 0) {
+		wh := (prevPower + power) / 2 * hours
+		if wh >= 0 {
+			return wh, 0
+		}
+		return 0, -wh
+	}
+
+	// the power crossed zero, split the interval at the crossing point
+	f := prevPower / (prevPower - power)
+	first := prevPower / 2 * hours * f
+	second := power / 2 * hours * (1 - f)
+	if first >= 0 {
+		return first, -second
+	}
+	return second, -first
+}
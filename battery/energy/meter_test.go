@@ -0,0 +1,91 @@
+package energy
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// sample is a power reading in W taken offset after the start.
+type sample struct {
+	offset time.Duration
+	power  float64
+}
+
+func TestMeter(t *testing.T) {
+	tests := []struct {
+		name       string
+		samples    []sample
+		discharged float64
+		charged    float64
+	}{
+		{
+			name:       "single sample",
+			samples:    []sample{{0, 1000}},
+			discharged: 0,
+		},
+		{
+			name:       "constant discharge",
+			samples:    []sample{{0, 1200}, {time.Minute, 1200}, {2 * time.Minute, 1200}},
+			discharged: 40,
+		},
+		{
+			name:    "constant charge",
+			samples: []sample{{0, -600}, {time.Minute, -600}},
+			charged: 10,
+		},
+		{
+			name:       "ramp",
+			samples:    []sample{{0, 0}, {time.Minute, 1200}},
+			discharged: 10,
+		},
+		{
+			name:       "zero crossing in the middle",
+			samples:    []sample{{0, 600}, {time.Minute, -600}},
+			discharged: 2.5,
+			charged:    2.5,
+		},
+		{
+			name:       "zero crossing after three quarters",
+			samples:    []sample{{0, -1800}, {time.Minute, 600}},
+			discharged: 1.25,
+			charged:    11.25,
+		},
+		{
+			name:       "gap at the limit",
+			samples:    []sample{{0, 600}, {maxGap, 600}},
+			discharged: 50,
+		},
+		{
+			name:       "gap above the limit",
+			samples:    []sample{{0, 600}, {maxGap + time.Second, 600}, {maxGap + time.Second + time.Minute, 600}},
+			discharged: 10,
+		},
+		{
+			name:       "clock jump backwards",
+			samples:    []sample{{time.Hour, 600}, {0, 600}, {time.Minute, 600}},
+			discharged: 10,
+		},
+	}
+	start := time.Date(2024, 8, 14, 20, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Meter
+			var discharged, charged float64
+			for _, s := range tt.samples {
+				d, c := m.Add(start.Add(s.offset), s.power)
+				if d < 0 || c < 0 {
+					t.Fatalf("negative energy %v/%v at %s", d, c, s.offset)
+				}
+				discharged += d
+				charged += c
+			}
+			if math.Abs(discharged-tt.discharged) > 1e-9 {
+				t.Errorf("discharged = %v Wh, want %v", discharged, tt.discharged)
+			}
+			if math.Abs(charged-tt.charged) > 1e-9 {
+				t.Errorf("charged = %v Wh, want %v", charged, tt.charged)
+			}
+		})
+	}
+}
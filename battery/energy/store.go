@@ -0,0 +1,148 @@
+package energy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gok-pi/internal/lib/clock"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	dayLayout    = "2006-01-02"
+	saveInterval = time.Minute
+)
+
+// Totals is the energy in Wh moved through a battery.
+type Totals struct {
+	DischargedWh float64 `json:"discharged_wh"`
+	ChargedWh    float64 `json:"charged_wh"`
+}
+
+// Daily maps day ("2006-01-02") to battery name to schedule label to totals.
+type Daily map[string]map[string]map[string]*Totals
+
+// Store keeps daily energy totals and persists them to a JSON file.
+// It is safe for concurrent use by several discharge workers.
+type Store struct {
+	path      string
+	retention int
+	clock     clock.Clock
+	mutex     sync.Mutex
+	days      Daily
+	dirty     bool
+	savedAt   time.Time
+}
+
+// NewStore loads the totals saved at path, if any. Days older than retentionDays are dropped
+// on save; zero keeps all days.
+func NewStore(path string, retentionDays int) (*Store, error) {
+	s := &Store{
+		path:      path,
+		retention: retentionDays,
+		clock:     clock.Real{},
+		days:      make(Daily),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading energy totals: %w", err)
+	}
+	if err = json.Unmarshal(data, &s.days); err != nil {
+		return nil, fmt.Errorf("unmarshal energy totals: %w", err)
+	}
+	return s, nil
+}
+
+// SetClock replaces the wall clock used for the save interval and the retention.
+func (s *Store) SetClock(clock clock.Clock) {
+	s.clock = clock
+}
+
+// Add accumulates energy for the day of t. The file is written at most once per saveInterval.
+func (s *Store) Add(t time.Time, battery, schedule string, discharged, charged float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	day := t.Format(dayLayout)
+	if s.days[day] == nil {
+		s.days[day] = make(map[string]map[string]*Totals)
+	}
+	if s.days[day][battery] == nil {
+		s.days[day][battery] = make(map[string]*Totals)
+	}
+	totals := s.days[day][battery][schedule]
+	if totals == nil {
+		totals = &Totals{}
+		s.days[day][battery][schedule] = totals
+	}
+	totals.DischargedWh += discharged
+	totals.ChargedWh += charged
+	s.dirty = true
+
+	now := s.clock.Now()
+	if now.Sub(s.savedAt) < saveInterval {
+		return nil
+	}
+	return s.save(now)
+}
+
+// Day returns a copy of the totals of the given day per battery and schedule.
+func (s *Store) Day(t time.Time) map[string]map[string]Totals {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := make(map[string]map[string]Totals)
+	for battery, schedules := range s.days[t.Format(dayLayout)] {
+		result[battery] = make(map[string]Totals)
+		for schedule, totals := range schedules {
+			result[battery][schedule] = *totals
+		}
+	}
+	return result
+}
+
+// Flush writes pending totals to the file.
+func (s *Store) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.save(s.clock.Now())
+}
+
+// save prunes expired days and atomically replaces the file. Must be called with the mutex held.
+func (s *Store) save(now time.Time) error {
+	if s.retention > 0 {
+		oldest := now.AddDate(0, 0, -s.retention).Format(dayLayout)
+		for day := range s.days {
+			if day < oldest {
+				delete(s.days, day)
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(s.days, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal energy totals: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("creating energy directory: %w", err)
+	}
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing energy totals: %w", err)
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("replacing energy totals: %w", err)
+	}
+	s.dirty = false
+	s.savedAt = now
+	return nil
+}
@@ -0,0 +1,118 @@
+package energy
+
+import (
+	"gok-pi/internal/lib/clock"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "energy", "energy.json")
+	now := time.Date(2024, 8, 14, 20, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+
+	s, err := NewStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetClock(clk)
+	if err = s.Add(now, "b1", "night", 100, 0); err != nil {
+		t.Fatal(err)
+	}
+	// within the save interval the totals are only kept in memory
+	clk.Set(now.Add(10 * time.Second))
+	if err = s.Add(now.Add(10*time.Second), "b1", "night", 50, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Add(now.Add(10*time.Second), "b2", "none", 0, 30); err != nil {
+		t.Fatal(err)
+	}
+	saved := load(t, path)
+	if got := saved.Day(now)["b1"]["night"]; got.DischargedWh != 100 {
+		t.Errorf("saved before flush = %+v, want 100 Wh discharged", got)
+	}
+
+	// flushing on shutdown writes the pending totals
+	if err = s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]Totals{
+		"b1": {"night": {DischargedWh: 150, ChargedWh: 2}},
+		"b2": {"none": {ChargedWh: 30}},
+	}
+	if got := load(t, path).Day(now); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded = %+v, want %+v", got, want)
+	}
+
+	// after the save interval the next sample writes the file
+	clk.Set(now.Add(2 * time.Minute))
+	if err = s.Add(now.Add(2*time.Minute), "b1", "night", 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := load(t, path).Day(now)["b1"]["night"]; got.DischargedWh != 160 {
+		t.Errorf("saved after the save interval = %+v, want 160 Wh discharged", got)
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "energy.json")
+	now := time.Date(2024, 8, 14, 20, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+
+	s, err := NewStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetClock(clk)
+	for _, day := range []int{-3, -2, 0} {
+		if err = s.Add(now.AddDate(0, 0, day), "b1", "none", 1, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := load(t, path)
+	for day, kept := range map[int]bool{-3: false, -2: true, 0: true} {
+		if got := len(loaded.Day(now.AddDate(0, 0, day))) > 0; got != kept {
+			t.Errorf("day %d kept = %v, want %v", day, got, kept)
+		}
+	}
+}
+
+func TestStoreFlushWithoutChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "energy.json")
+	s, err := NewStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file written without totals: %v", err)
+	}
+}
+
+func TestStoreInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "energy.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore(path, 0); err == nil {
+		t.Error("no error for an invalid file")
+	}
+}
+
+func load(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := NewStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
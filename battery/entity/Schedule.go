@@ -1,10 +1,22 @@
 package entity
 
+// Schedule is a daily discharge window. When enabled windows overlap, the schedule with
+// the highest Priority applies, then the one with the highest (most restrictive) SocLimit.
 type Schedule struct {
+	Name        string `yaml:"name"`
 	StartTime   string `yaml:"start_time" env-default:"18:00"`
 	StopTime    string `yaml:"stop_time" env-default:"22:00"`
 	BatteryName string `yaml:"battery_name" env-required:"battery1"`
 	Enabled     bool   `yaml:"enabled" env-default:"true"`
 	PowerLimit  int    `yaml:"power_limit" env-default:"1000"`
 	SocLimit    int    `yaml:"soc_limit" env-default:"50"`
+	Priority    int    `yaml:"priority" env-default:"0"`
+}
+
+// Label identifies the schedule in metrics and logs: its name if set, the time window otherwise.
+func (s Schedule) Label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.StartTime + "-" + s.StopTime
 }
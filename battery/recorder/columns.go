@@ -0,0 +1,120 @@
+package recorder
+
+import (
+	"fmt"
+	"gok-pi/battery/entity"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const timeColumn = "time"
+
+// column maps a SystemStatus field to a CSV column named after its JSON key.
+type column struct {
+	name  string
+	index int
+	kind  reflect.Kind
+}
+
+// columns lists the scalar SystemStatus fields in declaration order.
+// Fields without a fixed type (Sac2, Sac3) are not recorded.
+var columns = statusColumns()
+
+func statusColumns() []column {
+	var cols []column
+	t := reflect.TypeOf(entity.SystemStatus{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch f.Type.Kind() {
+		case reflect.Float64, reflect.Bool, reflect.String:
+		default:
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		cols = append(cols, column{name: name, index: i, kind: f.Type.Kind()})
+	}
+	return cols
+}
+
+// Header returns the CSV column names.
+func Header() []string {
+	h := []string{timeColumn}
+	for _, c := range columns {
+		h = append(h, c.name)
+	}
+	return h
+}
+
+// Row returns the record formatted as CSV columns in Header order.
+func Row(r Record) []string {
+	return encode(r.Time, r.Status)
+}
+
+func encode(t time.Time, status *entity.SystemStatus) []string {
+	v := reflect.ValueOf(status).Elem()
+	row := []string{t.Format(time.RFC3339)}
+	for _, c := range columns {
+		f := v.Field(c.index)
+		switch c.kind {
+		case reflect.Float64:
+			row = append(row, strconv.FormatFloat(f.Float(), 'f', -1, 64))
+		case reflect.Bool:
+			row = append(row, strconv.FormatBool(f.Bool()))
+		default:
+			row = append(row, f.String())
+		}
+	}
+	return row
+}
+
+// decode parses a row written by encode using the file's header, so that files written
+// before a field was added can still be read.
+func decode(head, row []string) (time.Time, *entity.SystemStatus, error) {
+	var t time.Time
+	status := &entity.SystemStatus{}
+	v := reflect.ValueOf(status).Elem()
+
+	byName := make(map[string]column, len(columns))
+	for _, c := range columns {
+		byName[c.name] = c
+	}
+
+	for i, name := range head {
+		if i >= len(row) {
+			break
+		}
+		value := row[i]
+		if name == timeColumn {
+			var err error
+			t, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				return t, nil, fmt.Errorf("parsing time: %w", err)
+			}
+			continue
+		}
+		c, ok := byName[name]
+		if !ok {
+			continue
+		}
+		f := v.Field(c.index)
+		switch c.kind {
+		case reflect.Float64:
+			x, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return t, nil, fmt.Errorf("parsing %s: %w", name, err)
+			}
+			f.SetFloat(x)
+		case reflect.Bool:
+			x, err := strconv.ParseBool(value)
+			if err != nil {
+				return t, nil, fmt.Errorf("parsing %s: %w", name, err)
+			}
+			f.SetBool(x)
+		default:
+			f.SetString(value)
+		}
+	}
+	return t, status, nil
+}
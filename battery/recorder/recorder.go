@@ -0,0 +1,242 @@
+package recorder
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"gok-pi/battery/entity"
+	"gok-pi/internal/lib/sl"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dayLayout = "2006-01-02"
+	fileExt   = ".csv"
+)
+
+// Record is a battery status as received at Time.
+type Record struct {
+	Time    time.Time            `json:"time"`
+	Battery string               `json:"battery"`
+	Status  *entity.SystemStatus `json:"status"`
+}
+
+type dayFile struct {
+	day    string
+	file   *os.File
+	writer *csv.Writer
+}
+
+// Recorder appends battery statuses to daily CSV files in <dir>/<battery>/<day>.csv
+// and removes files older than the retention period. It is safe for concurrent use.
+type Recorder struct {
+	dir       string
+	retention int
+	mutex     sync.Mutex
+	files     map[string]*dayFile
+	log       *slog.Logger
+}
+
+func New(dir string, retentionDays int, log *slog.Logger) *Recorder {
+	return &Recorder{
+		dir:       dir,
+		retention: retentionDays,
+		files:     make(map[string]*dayFile),
+		log:       log.With(sl.Module("recorder")),
+	}
+}
+
+// Record appends the status to the battery's file for the day of t.
+func (r *Recorder) Record(battery string, t time.Time, status *entity.SystemStatus) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, err := r.file(battery, t)
+	if err != nil {
+		return err
+	}
+	if err = f.writer.Write(encode(t, status)); err != nil {
+		return fmt.Errorf("writing record: %w", err)
+	}
+	f.writer.Flush()
+	return f.writer.Error()
+}
+
+// Close closes all open files.
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var errs []error
+	for battery, f := range r.files {
+		errs = append(errs, f.file.Close())
+		delete(r.files, battery)
+	}
+	return errors.Join(errs...)
+}
+
+// file returns the open file for the battery and day, rotating to a new file and
+// pruning expired ones when the day changes. Must be called with the mutex held.
+func (r *Recorder) file(battery string, t time.Time) (*dayFile, error) {
+	day := t.Format(dayLayout)
+	if f, ok := r.files[battery]; ok {
+		if f.day == day {
+			return f, nil
+		}
+		_ = f.file.Close()
+		delete(r.files, battery)
+	}
+
+	dir := filepath.Join(r.dir, battery)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating history directory: %w", err)
+	}
+	r.prune(battery, t)
+	path := filepath.Join(dir, day+fileExt)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening history file: %w", err)
+	}
+	f := &dayFile{day: day, file: file, writer: csv.NewWriter(file)}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("checking history file: %w", err)
+	}
+	if info.Size() == 0 {
+		if err = f.writer.Write(Header()); err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("writing header: %w", err)
+		}
+	}
+	r.files[battery] = f
+	return f, nil
+}
+
+// prune removes the battery's files older than the retention period.
+func (r *Recorder) prune(battery string, now time.Time) {
+	if r.retention <= 0 {
+		return
+	}
+	oldest := now.AddDate(0, 0, -r.retention).Format(dayLayout)
+	days, err := listDays(filepath.Join(r.dir, battery))
+	if err != nil {
+		r.log.With(sl.Err(err)).Warn("listing history files")
+		return
+	}
+	for _, day := range days {
+		if day >= oldest {
+			break
+		}
+		path := filepath.Join(r.dir, battery, day+fileExt)
+		if err = os.Remove(path); err != nil {
+			r.log.With(sl.Err(err), slog.String("file", path)).Warn("removing history file")
+		}
+	}
+}
+
+// Batteries returns the names of all batteries with recorded history in dir.
+func Batteries(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading history directory: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// Read calls fn for every record of the battery with from <= Time < to, in time order.
+func Read(dir, battery string, from, to time.Time, fn func(Record) error) error {
+	days, err := listDays(filepath.Join(dir, battery))
+	if err != nil {
+		return err
+	}
+	first := from.AddDate(0, 0, -1).Format(dayLayout)
+	last := to.AddDate(0, 0, 1).Format(dayLayout)
+	for _, day := range days {
+		if day < first || day > last {
+			continue
+		}
+		err = readFile(filepath.Join(dir, battery, day+fileExt), func(t time.Time, status *entity.SystemStatus) error {
+			if t.Before(from) || !t.Before(to) {
+				return nil
+			}
+			return fn(Record{Time: t, Battery: battery, Status: status})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(path string, fn func(time.Time, *entity.SystemStatus) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening history file: %w", err)
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	head, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		t, status, err := decode(head, row)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if err = fn(t, status); err != nil {
+			return err
+		}
+	}
+}
+
+// listDays returns the days of the history files in dir, sorted ascending.
+func listDays(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading history directory: %w", err)
+	}
+	var days []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		day := strings.TrimSuffix(name, fileExt)
+		if _, err = time.Parse(dayLayout, day); err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days, nil
+}
@@ -0,0 +1,142 @@
+package simulator
+
+import (
+	"gok-pi/battery/entity"
+	"time"
+)
+
+const (
+	// maxGap is the longest interval between two samples that is simulated;
+	// longer gaps in the history are skipped.
+	maxGap = 5 * time.Minute
+
+	opModeManual = "1"
+	opModeAuto   = "2"
+)
+
+// Totals is the energy in Wh moved during a replay.
+type Totals struct {
+	DischargedWh float64
+	ChargedWh    float64
+	// ControlledWh is the part of DischargedWh discharged by a manual setpoint.
+	ControlledWh float64
+	GridImportWh float64
+	GridExportWh float64
+}
+
+// Battery models a battery driven by recorded production and consumption. In automatic
+// mode it covers the household deficit and stores the surplus up to MaxPower; in manual
+// mode it discharges with the setpoint regardless of the load, exporting the excess.
+// It implements discharger.Client, so the discharge worker can operate it.
+type Battery struct {
+	capacityWh float64
+	maxPower   float64
+	energyWh   float64
+	mode       string
+	setpoint   int
+	power      float64
+	sample     *entity.SystemStatus
+	last       time.Time
+	totals     Totals
+}
+
+// NewBattery creates a battery in automatic mode holding energyWh of capacityWh.
+func NewBattery(capacityWh, energyWh, maxPower float64) *Battery {
+	return &Battery{
+		capacityWh: capacityWh,
+		maxPower:   maxPower,
+		energyWh:   min(max(energyWh, 0), capacityWh),
+		mode:       opModeAuto,
+	}
+}
+
+// Advance simulates the time since the previous sample with the load of that sample,
+// then takes the recorded sample at t as the current household load.
+func (b *Battery) Advance(t time.Time, sample *entity.SystemStatus) {
+	if b.sample != nil && !b.last.IsZero() {
+		if dt := t.Sub(b.last); dt > 0 && dt <= maxGap {
+			b.integrate(dt.Hours())
+		}
+	}
+	b.last = t
+	b.sample = sample
+	b.power = b.batteryPower(1.0 / 3600)
+}
+
+// integrate moves energy for the given hours with the current load and mode.
+func (b *Battery) integrate(hours float64) {
+	power := b.batteryPower(hours)
+	grid := b.sample.ConsumptionW - b.sample.ProductionW - power
+
+	b.energyWh -= power * hours
+	if power > 0 {
+		b.totals.DischargedWh += power * hours
+		if b.mode == opModeManual {
+			b.totals.ControlledWh += power * hours
+		}
+	} else {
+		b.totals.ChargedWh -= power * hours
+	}
+	if grid > 0 {
+		b.totals.GridImportWh += grid * hours
+	} else {
+		b.totals.GridExportWh -= grid * hours
+	}
+}
+
+// batteryPower returns the battery power in W, positive when discharging, limited
+// by the energy available, or the capacity left, within the given hours.
+func (b *Battery) batteryPower(hours float64) float64 {
+	var power float64
+	if b.mode == opModeManual {
+		power = float64(b.setpoint)
+	} else {
+		power = min(max(b.sample.ConsumptionW-b.sample.ProductionW, -b.maxPower), b.maxPower)
+	}
+	if power > 0 {
+		return min(power, b.energyWh/hours)
+	}
+	return max(power, -(b.capacityWh-b.energyWh)/hours)
+}
+
+// Totals returns the energy moved so far.
+func (b *Battery) Totals() Totals {
+	return b.totals
+}
+
+// Status returns the recorded sample with the battery values replaced by the model.
+func (b *Battery) Status() (*entity.SystemStatus, error) {
+	s := *b.sample
+	soc := b.energyWh / b.capacityWh * 100
+	s.RSOC = soc
+	s.USOC = soc
+	s.RemainingCapacityWh = b.energyWh
+	s.OperatingMode = b.mode
+	s.PacTotalW = b.power
+	s.BatteryDischarging = b.power > 0
+	s.BatteryCharging = b.power < 0
+	s.GridFeedInW = b.sample.ProductionW - b.sample.ConsumptionW + b.power
+	return &s, nil
+}
+
+func (b *Battery) StartDischarge(power int) error {
+	b.setpoint = power
+	b.power = b.batteryPower(1.0 / 3600)
+	return nil
+}
+
+func (b *Battery) StopDischarge() error {
+	return b.StartDischarge(0)
+}
+
+func (b *Battery) SwitchOperatingModeToManual(string) error {
+	b.mode = opModeManual
+	return b.StartDischarge(b.setpoint)
+}
+
+func (b *Battery) SwitchOperatingModeToAuto(string) error {
+	b.mode = opModeAuto
+	b.setpoint = 0
+	b.power = b.batteryPower(1.0 / 3600)
+	return nil
+}
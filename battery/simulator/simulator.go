@@ -0,0 +1,77 @@
+package simulator
+
+import (
+	"fmt"
+	"gok-pi/battery/discharger"
+	"gok-pi/battery/recorder"
+	"gok-pi/battery/strategy"
+	"gok-pi/internal/lib/clock"
+	"log/slog"
+)
+
+// Baseline is the name of the replay without any control, the battery stays in automatic mode.
+const Baseline = "none"
+
+// Options describe the simulated battery and the energy prices per kWh.
+type Options struct {
+	CapacityWh  float64
+	MaxPower    float64
+	PowerLimit  int
+	SocLimit    int
+	ImportPrice float64
+	ExportPrice float64
+}
+
+// Result is the outcome of replaying a history with one strategy, labelled by its name.
+type Result struct {
+	Battery  string
+	Strategy string
+	Totals
+	// Cost is the price of the imported minus the revenue of the exported energy.
+	Cost float64
+}
+
+// Run replays the records of one battery, ordered by time, through a discharge worker
+// using the strategy on a modelled battery. The worker runs on a fake clock set to the
+// time of each record.
+// With a nil strategy the baseline without control is replayed.
+func Run(records []recorder.Record, name, label string, st strategy.Strategy, opts Options, log *slog.Logger) (Result, error) {
+	result := Result{Battery: name, Strategy: label}
+	if len(records) == 0 {
+		return result, fmt.Errorf("no records of battery %s", name)
+	}
+
+	first := records[0].Status
+	capacity := opts.CapacityWh
+	if capacity <= 0 && first.RSOC > 0 {
+		capacity = first.RemainingCapacityWh * 100 / first.RSOC
+	}
+	if capacity <= 0 {
+		return result, fmt.Errorf("battery capacity unknown, set it explicitly")
+	}
+	battery := NewBattery(capacity, first.RemainingCapacityWh, opts.MaxPower)
+	clk := clock.NewFake(records[0].Time)
+
+	var worker *discharger.Discharge
+	if st != nil {
+		var err error
+		worker, err = discharger.New(name, true, battery, st, log)
+		if err != nil {
+			return result, err
+		}
+		worker.SetLimits(opts.PowerLimit, opts.SocLimit)
+		worker.SetClock(clk)
+	}
+
+	for _, r := range records {
+		clk.Set(r.Time)
+		battery.Advance(r.Time, r.Status)
+		if worker != nil {
+			worker.Step()
+		}
+	}
+
+	result.Totals = battery.Totals()
+	result.Cost = (result.GridImportWh*opts.ImportPrice - result.GridExportWh*opts.ExportPrice) / 1000
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package strategy
+
+import (
+	"fmt"
+	"gok-pi/battery/entity"
+	"gok-pi/integrations/forecast"
+	"gok-pi/internal/lib/sl"
+	"log/slog"
+	"time"
+)
+
+// FixedWindow discharges the battery with the schedule's power limit while the current time
+// falls within an enabled schedule window and the USoC is above the schedule's SoC limit.
+// Overlapping windows are resolved as described in Schedules.
+type FixedWindow struct {
+	schedules *Schedules
+	forecast  *ForecastLimit
+	log       *slog.Logger
+}
+
+func NewFixedWindow(schedules []entity.Schedule, forecast *ForecastLimit, log *slog.Logger) *FixedWindow {
+	f := &FixedWindow{
+		forecast: forecast,
+		log:      log.With(sl.Module("strategy.fixed")),
+	}
+
+	var errs []error
+	f.schedules, errs = NewSchedules(schedules)
+	for _, err := range errs {
+		f.log.With(sl.Err(err)).Error("skipping schedule")
+	}
+	for _, o := range f.schedules.Overlaps() {
+		f.log.With(
+			slog.String("first", fmt.Sprintf("%s-%s", o.First.StartTime, o.First.StopTime)),
+			slog.Int("first_priority", o.First.Priority),
+			slog.String("second", fmt.Sprintf("%s-%s", o.Second.StartTime, o.Second.StopTime)),
+			slog.Int("second_priority", o.Second.Priority),
+			slog.Bool("resolved_by_priority", o.Resolved),
+		).Warn("overlapping schedules, the first one takes precedence")
+	}
+	return f
+}
+
+func (f *FixedWindow) Decide(in Input) Decision {
+	schedule := f.schedules.Active(in.Time)
+	if schedule == nil {
+		return Decision{Mode: ModeAuto, Reason: "outside schedule"}
+	}
+	if in.Status == nil {
+		return Decision{Mode: ModeAuto, Reason: "no status", Schedule: schedule}
+	}
+	socLimit := f.socLimit(schedule, in.SocLimit, in.Time)
+	if in.Status.USOC <= socLimit {
+		return Decision{Mode: ModeAuto, Reason: ReasonSocLimit, Schedule: schedule}
+	}
+	return Decision{Mode: ModeDischarge, Power: schedule.PowerLimit, Reason: "schedule", Schedule: schedule}
+}
+
+func (f *FixedWindow) NextBoundary(now time.Time) (time.Time, bool) {
+	return f.schedules.NextBoundary(now)
+}
+
+func (f *FixedWindow) SetScheduleEnabled(label string, enabled bool) error {
+	if !f.schedules.SetEnabled(label, enabled) {
+		return fmt.Errorf("unknown schedule: %s", label)
+	}
+	return nil
+}
+
+func (f *FixedWindow) ScheduleStates() map[string]bool {
+	return f.schedules.States()
+}
+
+// socLimit returns the schedule SoC limit, or the override if positive, raised if low
+// PV production is expected. The provider only returns its cached forecast, so this is
+// cheap on every decision. On forecast errors the limit is kept unchanged.
+func (f *FixedWindow) socLimit(schedule *entity.Schedule, override float64, now time.Time) float64 {
+	limit := float64(schedule.SocLimit)
+	if override > 0 {
+		limit = override
+	}
+	if f.forecast == nil || f.forecast.Provider == nil {
+		return limit
+	}
+	day := forecast.NextProductionDay(now)
+	production, err := f.forecast.Provider.Production(day)
+	if err != nil {
+		f.log.With(sl.Err(err)).Warn("getting production forecast")
+		return limit
+	}
+	raised := forecast.SocLimit(limit, float64(f.forecast.SocLimit), production, f.forecast.MinProductionWh)
+	if raised != limit {
+		f.log.With(
+			slog.Float64("production", production),
+			slog.Float64("schedule_soc_limit", limit),
+			slog.Float64("soc_limit", raised),
+		).Debug("low production expected, raising SoC limit")
+	}
+	return raised
+}
@@ -0,0 +1,154 @@
+package strategy
+
+import (
+	"fmt"
+	"gok-pi/battery/entity"
+	"gok-pi/internal/lib/timer"
+	"sort"
+	"time"
+)
+
+type scheduleWindow struct {
+	schedule entity.Schedule
+	window   timer.Window
+}
+
+// Schedules is the set of schedules of a battery ordered by precedence: highest priority
+// first, then highest (most restrictive) SoC limit, then configuration order.
+// Only enabled schedules are taken into account; they may be enabled at runtime.
+type Schedules struct {
+	items []scheduleWindow
+}
+
+// Overlap is a pair of enabled schedules whose windows share some time of the day.
+// Resolved is false when neither of them takes precedence by priority.
+type Overlap struct {
+	First    entity.Schedule
+	Second   entity.Schedule
+	Resolved bool
+}
+
+// NewSchedules parses the schedules. Schedules with invalid times are skipped
+// and reported in the returned errors.
+func NewSchedules(schedules []entity.Schedule) (*Schedules, []error) {
+	var errs []error
+	s := &Schedules{}
+	for i, schedule := range schedules {
+		window, err := timer.ParseWindow(schedule.StartTime, schedule.StopTime)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schedule %d (%s-%s): %w", i, schedule.StartTime, schedule.StopTime, err))
+			continue
+		}
+		s.items = append(s.items, scheduleWindow{schedule: schedule, window: window})
+	}
+	sort.SliceStable(s.items, func(i, j int) bool {
+		a, b := s.items[i].schedule, s.items[j].schedule
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.SocLimit > b.SocLimit
+	})
+	return s, errs
+}
+
+// Active returns the schedule that applies at the given time, or nil if there is none.
+func (s *Schedules) Active(now time.Time) *entity.Schedule {
+	for i := range s.items {
+		if s.items[i].schedule.Enabled && s.items[i].window.Contains(now) {
+			return &s.items[i].schedule
+		}
+	}
+	return nil
+}
+
+// NextBoundary returns the first schedule start or stop after now.
+// It returns false if there are no schedules.
+func (s *Schedules) NextBoundary(now time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, item := range s.items {
+		if !item.schedule.Enabled || item.window.Start == item.window.Stop {
+			continue
+		}
+		boundary := item.window.NextBoundary(now)
+		if next.IsZero() || boundary.Before(next) {
+			next = boundary
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Overlaps returns all pairs of schedules with intersecting windows.
+func (s *Schedules) Overlaps() []Overlap {
+	var overlaps []Overlap
+	for i := 0; i < len(s.items); i++ {
+		for j := i + 1; j < len(s.items); j++ {
+			if !s.items[i].schedule.Enabled || !s.items[j].schedule.Enabled {
+				continue
+			}
+			if !s.items[i].window.Overlaps(s.items[j].window) {
+				continue
+			}
+			overlaps = append(overlaps, Overlap{
+				First:    s.items[i].schedule,
+				Second:   s.items[j].schedule,
+				Resolved: s.items[i].schedule.Priority != s.items[j].schedule.Priority,
+			})
+		}
+	}
+	return overlaps
+}
+
+// SetEnabled enables or disables all schedules with the given label.
+// It returns false if there is no such schedule.
+func (s *Schedules) SetEnabled(label string, enabled bool) bool {
+	found := false
+	for i := range s.items {
+		if s.items[i].schedule.Label() == label {
+			s.items[i].schedule.Enabled = enabled
+			found = true
+		}
+	}
+	return found
+}
+
+// States returns whether each schedule is enabled, by label.
+func (s *Schedules) States() map[string]bool {
+	states := make(map[string]bool, len(s.items))
+	for _, item := range s.items {
+		states[item.schedule.Label()] = item.schedule.Enabled
+	}
+	return states
+}
+
+// Period is a span of time in which the same schedule applies. Schedule is nil
+// for periods outside any schedule.
+type Period struct {
+	Start    time.Time
+	Stop     time.Time
+	Schedule *entity.Schedule
+}
+
+// Plan returns the consecutive periods between from and to, evaluating Active at every
+// boundary just like the discharge worker does. Adjacent periods of the same schedule
+// are merged, e.g. when a lower priority schedule starts within a higher priority one.
+func (s *Schedules) Plan(from, to time.Time) []Period {
+	var periods []Period
+	for t := from; t.Before(to); {
+		stop := to
+		if next, ok := s.NextBoundary(t); ok && next.Before(to) {
+			stop = next
+		}
+		active := s.Active(t)
+		if n := len(periods); n > 0 && sameSchedule(periods[n-1].Schedule, active) {
+			periods[n-1].Stop = stop
+		} else {
+			periods = append(periods, Period{Start: t, Stop: stop, Schedule: active})
+		}
+		t = stop
+	}
+	return periods
+}
+
+func sameSchedule(a, b *entity.Schedule) bool {
+	return a == b || (a != nil && b != nil && *a == *b)
+}
@@ -0,0 +1,113 @@
+package strategy
+
+import (
+	"fmt"
+	"gok-pi/battery/entity"
+	"gok-pi/integrations/forecast"
+	"log/slog"
+	"time"
+)
+
+const (
+	NameFixedWindow = "fixed_window"
+)
+
+type Mode int
+
+const (
+	// ModeAuto leaves the battery to the controller's self-consumption logic.
+	ModeAuto Mode = iota
+	// ModeDischarge switches the controller to manual mode with a discharge setpoint.
+	ModeDischarge
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeAuto:
+		return "auto"
+	case ModeDischarge:
+		return "discharge"
+	}
+	return fmt.Sprintf("mode(%d)", int(m))
+}
+
+// Sample is a battery status received at a given time.
+type Sample struct {
+	Time   time.Time
+	Status *entity.SystemStatus
+}
+
+// Input is everything a strategy may base its decision on.
+// History holds the most recent samples, oldest first, including the current one.
+// SocLimit, if positive, replaces the configured SoC limit, e.g. when set by a user command.
+type Input struct {
+	Status   *entity.SystemStatus
+	Time     time.Time
+	History  []Sample
+	SocLimit float64
+}
+
+// ReasonSocLimit is the decision reason when a discharge ends because the battery
+// reached its SoC limit.
+const ReasonSocLimit = "battery level reached the limit"
+
+// Decision is the desired battery state. Power is the discharge setpoint in W and is
+// only meaningful for ModeDischarge. Schedule is the schedule in effect, if any, even
+// when it does not result in a discharge.
+type Decision struct {
+	Mode     Mode
+	Power    int
+	Reason   string
+	Schedule *entity.Schedule
+}
+
+// Strategy decides how the battery should be operated for the given input.
+// Implementations must not call the battery API and must not depend on the wall clock,
+// so that the same policy can be evaluated offline.
+type Strategy interface {
+	Decide(in Input) Decision
+}
+
+// Boundaries is implemented by strategies whose decisions change at known times of the day.
+type Boundaries interface {
+	// NextBoundary returns the first time after now at which the decision may change.
+	NextBoundary(now time.Time) (time.Time, bool)
+}
+
+// ScheduleToggler is implemented by strategies whose schedules can be enabled at runtime.
+type ScheduleToggler interface {
+	SetScheduleEnabled(label string, enabled bool) error
+	// ScheduleStates returns whether each schedule is enabled, by label.
+	ScheduleStates() map[string]bool
+}
+
+// ForecastLimit raises schedule SoC limits when low PV production is expected. The Provider
+// is asked on every decision and must return its cached forecast without blocking.
+type ForecastLimit struct {
+	Provider        forecast.Provider
+	MinProductionWh float64
+	SocLimit        int
+}
+
+type Options struct {
+	Schedules []entity.Schedule
+	Forecast  *ForecastLimit
+}
+
+// Known reports whether a strategy with the given name exists.
+func Known(name string) bool {
+	switch name {
+	case NameFixedWindow, "":
+		return true
+	}
+	return false
+}
+
+// New creates a strategy by its configured name.
+func New(name string, opts Options, log *slog.Logger) (Strategy, error) {
+	switch name {
+	case NameFixedWindow, "":
+		return NewFixedWindow(opts.Schedules, opts.Forecast, log), nil
+	}
+	return nil, fmt.Errorf("unknown strategy: %s", name)
+}
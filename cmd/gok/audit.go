@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"gok-pi/battery/audit"
+	"gok-pi/internal/config"
+	"os"
+	"os/user"
+	"time"
+)
+
+// cliAudit records the control actions of a CLI command in the audit log, if enabled.
+// The source is "cli:" followed by the name of the user running the command.
+type cliAudit struct {
+	conf    config.Audit
+	battery string
+	source  string
+	reason  string
+	soc     float64
+}
+
+func newCliAudit(conf config.Audit, battery, reason string, soc float64) *cliAudit {
+	source := "cli"
+	if u, err := user.Current(); err == nil {
+		source += ":" + u.Username
+	}
+	return &cliAudit{conf: conf, battery: battery, source: source, reason: reason, soc: soc}
+}
+
+// record appends the action to the audit log. A failure to write it is only reported,
+// the action has already been taken.
+func (a *cliAudit) record(action string, power int, err error) {
+	if !a.conf.Enabled {
+		return
+	}
+	e := audit.Entry{
+		Time:      time.Now(),
+		Battery:   a.battery,
+		Action:    action,
+		Power:     power,
+		Reason:    a.reason,
+		Source:    a.source,
+		SocBefore: a.soc,
+	}
+	e.SetResult(err)
+	if err := audit.Append(a.conf.Path, e); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", a.battery, err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"gok-pi/battery/api-client"
+	"gok-pi/battery/entity"
+	"gok-pi/internal/config"
+	"log/slog"
+	"os"
+)
+
+// cliLogger logs to stderr, only warnings and errors unless verbose.
+func cliLogger(verbose bool) *slog.Logger {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
+// selectBatteries loads the configuration and returns the named battery, enabled or not,
+// or all enabled batteries if name is empty.
+func selectBatteries(configPath, name string) ([]config.BatteryConfig, error) {
+	conf, err := config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", configPath, err)
+	}
+	batteries, err := pickBatteries(conf, name)
+	if err != nil {
+		return nil, err
+	}
+	return batteries, resolveTokens(batteries)
+}
+
+// pickBatteries returns the named battery, enabled or not, or all enabled batteries
+// if name is empty.
+func pickBatteries(conf *config.Config, name string) ([]config.BatteryConfig, error) {
+	var batteries []config.BatteryConfig
+	for _, b := range conf.Batteries {
+		if (name == "" && b.Enabled) || b.Name == name {
+			batteries = append(batteries, b)
+		}
+	}
+	if len(batteries) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("unknown battery %q", name)
+		}
+		return nil, fmt.Errorf("no batteries enabled")
+	}
+	return batteries, nil
+}
+
+// selectBattery loads the configuration and returns it with the named battery. Without
+// a name the only enabled battery is returned, so that single battery setups need not
+// repeat its name.
+func selectBattery(configPath, name string) (*config.Config, config.BatteryConfig, error) {
+	conf, err := config.Load(configPath)
+	if err != nil {
+		return nil, config.BatteryConfig{}, fmt.Errorf("%s: %w", configPath, err)
+	}
+	batteries, err := pickBatteries(conf, name)
+	if err == nil {
+		err = resolveTokens(batteries)
+	}
+	if err != nil {
+		return nil, config.BatteryConfig{}, err
+	}
+	if len(batteries) > 1 {
+		return nil, config.BatteryConfig{}, fmt.Errorf("%d batteries enabled, select one with -battery", len(batteries))
+	}
+	return conf, batteries[0], nil
+}
+
+// resolveTokens loads the tokens of disabled batteries, which are not resolved on load.
+func resolveTokens(batteries []config.BatteryConfig) error {
+	for i := range batteries {
+		if batteries[i].Enabled {
+			continue
+		}
+		if err := batteries[i].ResolveToken(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batterySchedules returns all schedules of the battery, including disabled ones.
+func batterySchedules(conf *config.Config, name string) []entity.Schedule {
+	var schedules []entity.Schedule
+	for _, s := range conf.Schedules {
+		if s.BatteryName == name {
+			schedules = append(schedules, s)
+		}
+	}
+	return schedules
+}
+
+func newApiClient(b config.BatteryConfig, log *slog.Logger) *apiclient.ApiClient {
+	return apiclient.New(b.Name, b.Url, b.Token, log.With(slog.String("battery", b.Name)))
+}
+
+// operatingMode describes an EM_OperatingMode value.
+func operatingMode(mode string) string {
+	switch mode {
+	case "1":
+		return "manual"
+	case "2":
+		return "automatic"
+	default:
+		return "mode " + mode
+	}
+}
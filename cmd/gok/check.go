@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gok-pi/internal/config"
+	"os"
+)
+
+// check validates the configuration file and prints every problem found.
+// It exits non-zero if the configuration cannot be used by the daemon.
+func check(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := fs.String("conf", "config.yml", "path to config file")
+	_ = fs.Parse(args)
+
+	conf, err := config.Read(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *configPath, err)
+		return 1
+	}
+
+	for _, p := range conf.Warnings() {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", *configPath, p)
+	}
+
+	// both checks run, so that all problems are reported at once
+	var problems []config.Problem
+	for _, err := range []error{conf.Validate(), conf.ResolveSecrets()} {
+		var validationErr *config.ValidationError
+		if errors.As(err, &validationErr) {
+			problems = append(problems, validationErr.Problems...)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *configPath, err)
+			return 1
+		}
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *configPath, p)
+		}
+		fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(problems))
+		return 1
+	}
+
+	fmt.Printf("%s: ok\n", *configPath)
+	return 0
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gok-pi/battery/api-client"
+	"gok-pi/battery/audit"
+	"gok-pi/battery/discharger"
+	"gok-pi/battery/energy"
+	"gok-pi/battery/recorder"
+	"gok-pi/battery/strategy"
+	"gok-pi/integrations/forecast"
+	"gok-pi/integrations/mqtt"
+	"gok-pi/integrations/notify"
+	"gok-pi/internal/config"
+	"gok-pi/internal/lib/logger"
+	"gok-pi/internal/lib/sl"
+	"gok-pi/internal/lib/timer"
+	"gok-pi/metrics/health"
+	"gok-pi/metrics/observers"
+	"gok-pi/metrics/server"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// daemon runs the discharge workers of all enabled batteries until they stop.
+func daemon(args []string) int {
+	fs := flag.NewFlagSet("gok", flag.ExitOnError)
+	configPath := fs.String("conf", "config.yml", "path to config file")
+	logPath := fs.String("log", "/var/log", "path to log file directory, unless log.path is set")
+	_ = fs.Parse(args)
+
+	conf := config.MustLoad(*configPath)
+	logFile := conf.Log.Path
+	if logFile == "" {
+		logFile = filepath.Join(*logPath, "gok-pi.log")
+	}
+	service, err := logger.New(conf.Env, logger.Options{
+		Level:     conf.Log.Level,
+		Format:    conf.Log.Format,
+		Output:    conf.Log.Output,
+		Path:      logFile,
+		MaxSizeMb: conf.Log.MaxSizeMb,
+		MaxAge:    conf.Log.MaxAge,
+		MaxFiles:  conf.Log.MaxFiles,
+		Compress:  conf.Log.Compress,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "setting up logger: %s\n", err)
+		return 1
+	}
+	defer func() {
+		_ = service.Close()
+	}()
+	lg := service.Logger
+	watchLogLevel(service)
+
+	// on SIGINT or SIGTERM the workers stop, then the deferred closes persist and flush
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	lg.Info("starting gok-pi", slog.String("config", *configPath), slog.String("env", conf.Env))
+	lg.Debug("debug messages enabled")
+	// filter enabled batteries
+	var batteries []config.BatteryConfig
+	for _, b := range conf.Batteries {
+		if b.Enabled {
+			batteries = append(batteries, b)
+		}
+	}
+	lg.With(
+		slog.Int("batteries", len(batteries)),
+	).Info("loaded batteries config")
+
+	if len(batteries) == 0 {
+		lg.Warn("no batteries enabled")
+		return 0
+	}
+
+	// count enabled schedules, disabled ones are kept as they may be enabled at runtime
+	enabledSchedules := 0
+	for _, s := range conf.Schedules {
+		if s.Enabled {
+			enabledSchedules++
+		}
+	}
+	lg.With(
+		slog.Int("schedules", len(conf.Schedules)),
+		slog.Int("enabled", enabledSchedules),
+	).Info("loaded schedules")
+
+	if enabledSchedules == 0 {
+		lg.Warn("no schedules enabled, waiting for schedules to be enabled at runtime")
+	}
+
+	// the registry answers /readyz and decides on systemd watchdog notifications
+	healthRegistry := health.New(conf.Metrics.ReadyIntervals)
+	healthRegistry.SetConfigCheck(func() error {
+		c, err := config.Read(*configPath)
+		if err != nil {
+			return err
+		}
+		return c.Validate()
+	})
+	var observer observers.Observer = observers.Nop{}
+	if conf.Metrics.Enabled {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+		observer = observers.New(registry, conf.Metrics.LegacyNames)
+		lg.Info("starting metrics server", slog.String("bind", conf.Metrics.Bind), slog.String("port", conf.Metrics.Port))
+		go func() {
+			err := server.Listen(conf.Metrics.Bind, conf.Metrics.Port, registry, healthRegistry)
+			if err != nil {
+				lg.Error("metrics server", sl.Err(err))
+				return
+			}
+		}()
+	}
+
+	var energyStore *energy.Store
+	if conf.Energy.Enabled {
+		var err error
+		energyStore, err = energy.NewStore(conf.Energy.Path, conf.Energy.RetentionDays)
+		if err != nil {
+			lg.Error("loading energy totals", sl.Err(err))
+			return 1
+		}
+		lg.Info("energy totals enabled", slog.String("path", conf.Energy.Path))
+	}
+
+	var statusRecorder *recorder.Recorder
+	if conf.Recorder.Enabled {
+		statusRecorder = recorder.New(conf.Recorder.Path, conf.Recorder.RetentionDays, lg)
+		defer func() {
+			_ = statusRecorder.Close()
+		}()
+		lg.Info("status recorder enabled", slog.String("path", conf.Recorder.Path))
+	}
+
+	var auditLog *audit.Log
+	if conf.Audit.Enabled {
+		var err error
+		auditLog, err = audit.Open(conf.Audit.Path, conf.Audit.MaxSizeMb, conf.Audit.MaxFiles)
+		if err != nil {
+			lg.Error("opening audit log", sl.Err(err))
+			return 1
+		}
+		defer func() {
+			_ = auditLog.Close()
+		}()
+		lg.Info("audit log enabled", slog.String("path", conf.Audit.Path))
+	}
+
+	var mqttClient *mqtt.Client
+	if conf.Mqtt.Enabled {
+		mqttClient = mqtt.New(conf.Mqtt, lg)
+		mqttClient.SetLogLevel(service.SetLevel)
+		if err := mqttClient.Connect(); err != nil {
+			lg.Error("connecting to mqtt broker", sl.Err(err))
+			return 1
+		}
+		defer mqttClient.Close()
+	}
+
+	var notifier *notify.Notifier
+	if conf.Notify.Enabled {
+		notifier = notify.New(conf.Notify, lg)
+		defer notifier.Close()
+		lg.Info("notifications enabled")
+		if conf.Notify.DailySummary != "" && energyStore != nil {
+			at, _ := timer.ParseClock(conf.Notify.DailySummary)
+			names := make([]string, 0, len(batteries))
+			for _, b := range batteries {
+				names = append(names, b.Name)
+			}
+			go notifier.RunDailySummary(ctx, energyStore, at, names)
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	for _, b := range batteries {
+		wg.Add(1)
+		go func(workerId string) {
+			defer wg.Done()
+
+			log := lg.With(slog.String("battery", workerId))
+			api := apiclient.New(workerId, b.Url, b.Token, log)
+			api.SetObserver(observer)
+
+			// disabled schedules are passed as well, they may be enabled at runtime
+			schedules := batterySchedules(conf, b.Name)
+			var scheduleLabels []string
+			for _, s := range schedules {
+				scheduleLabels = append(scheduleLabels, s.Label())
+			}
+
+			opts := strategy.Options{Schedules: schedules}
+			if b.Forecast.Enabled {
+				var provider forecast.Provider
+				if b.Forecast.Url != "" {
+					provider = forecast.NewHttp(b.Forecast.Url, b.Forecast.Refresh, log)
+				} else {
+					provider = forecast.NewFile(b.Forecast.Path, b.Forecast.Refresh, log)
+				}
+				opts.Forecast = &strategy.ForecastLimit{
+					Provider:        provider,
+					MinProductionWh: b.Forecast.MinProductionWh,
+					SocLimit:        b.Forecast.SocLimit,
+				}
+			}
+
+			st, err := strategy.New(b.Strategy, opts, log)
+			if err != nil {
+				log.Error("creating discharge strategy", sl.Err(err))
+				return
+			}
+
+			worker, err := discharger.New(workerId, b.Discharge, api, st, log)
+			if err != nil {
+				log.Error("creating discharge worker", sl.Err(err))
+				return
+			}
+
+			worker.SetCapacityLimit(b.CapacityLimit)
+			worker.SetLimits(b.PowerLimit, b.SocLimit)
+			worker.SetObserver(observer)
+			worker.SetEnergyStore(energyStore)
+			worker.SetRecorder(statusRecorder)
+			worker.SetPolling(discharger.Polling{
+				Interval:       b.PollInterval,
+				Adaptive:       b.AdaptivePolling.Enabled,
+				Fast:           b.AdaptivePolling.FastInterval,
+				Slow:           b.AdaptivePolling.SlowInterval,
+				BoundaryMargin: b.AdaptivePolling.BoundaryMargin,
+			})
+			worker.SetHealth(healthRegistry)
+			worker.SetWatchdog(discharger.Watchdog{
+				Enabled:     conf.Watchdog.Enabled,
+				GracePeriod: conf.Watchdog.GracePeriod,
+			})
+			if auditLog != nil {
+				worker.SetAudit(auditLog)
+			}
+			if notifier != nil {
+				worker.SetNotifier(notifier, discharger.Alerts{
+					FailureThreshold: conf.Notify.FailureThreshold,
+					StuckManualAfter: conf.Notify.StuckManualAfter,
+				})
+			}
+			if mqttClient != nil {
+				worker.SetPublisher(mqttClient)
+				mqttClient.AddBattery(workerId, worker, scheduleLabels)
+			}
+
+			err = worker.Run(ctx)
+			if err != nil {
+				log.Error("running discharge worker", sl.Err(err))
+			}
+			log.Info("discharge worker stopped")
+		}(b.Name)
+	}
+	if err := health.Notify("READY=1"); err != nil {
+		lg.Warn("notifying systemd", sl.Err(err))
+	}
+	if interval := health.WatchdogInterval(); interval > 0 {
+		lg.Info("systemd watchdog enabled", slog.Duration("interval", interval))
+		go healthRegistry.RunWatchdog(ctx, interval, lg)
+	}
+	wg.Wait()
+	_ = health.Notify("STOPPING=1")
+
+	if energyStore != nil {
+		if err := energyStore.Flush(); err != nil {
+			lg.Error("saving energy totals", sl.Err(err))
+		}
+	}
+	lg.Info("gok-pi stopped")
+	return 0
+}
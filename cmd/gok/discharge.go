@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gok-pi/battery/audit"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// maxFailedPolls is the number of consecutive failed status polls after which a
+// discharge is stopped, as the SoC limit can no longer be enforced.
+const maxFailedPolls = 3
+
+// discharge switches a battery to manual mode and discharges it with the given power,
+// polling the status until -for has passed or, with -until-limit, until interrupted.
+// The discharge always ends at the SoC limit of the battery, after which the discharge
+// is stopped and automatic mode restored. It also ends when another client, such as the
+// watchdog of a running service, switches the battery back to automatic mode.
+func discharge(args []string) int {
+	fs := flag.NewFlagSet("discharge", flag.ExitOnError)
+	configPath := fs.String("conf", "config.yml", "path to config file")
+	name := fs.String("battery", "", "battery name, may be omitted if only one battery is enabled")
+	power := fs.Int("power", 0, "discharge power in W (default: power_limit of the battery)")
+	duration := fs.Duration("for", 0, "discharge duration, e.g. 30m")
+	untilLimit := fs.Bool("until-limit", false, "without -for, discharge until the SoC limit is reached or interrupted")
+	verbose := fs.Bool("v", false, "log API requests")
+	_ = fs.Parse(args)
+
+	conf, b, err := selectBattery(*configPath, *name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *power == 0 {
+		*power = b.PowerLimit
+	}
+	if *power <= 0 || (b.PowerLimit > 0 && *power > b.PowerLimit) {
+		fmt.Fprintf(os.Stderr, "invalid -power %d, expected 1 to %d W\n", *power, b.PowerLimit)
+		return 2
+	}
+	if *duration < 0 {
+		fmt.Fprintln(os.Stderr, "invalid -for, must not be negative")
+		return 2
+	}
+	if *duration == 0 && !*untilLimit {
+		fmt.Fprintln(os.Stderr, "either -for or -until-limit is required")
+		return 2
+	}
+	floor := float64(b.SocLimit)
+	if floor <= 0 {
+		fmt.Fprintf(os.Stderr, "%s: no soc_limit configured\n", b.Name)
+		return 1
+	}
+
+	api := newApiClient(b, cliLogger(*verbose))
+	s, err := api.Status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", b.Name, err)
+		return 1
+	}
+	if s.USOC <= floor {
+		fmt.Fprintf(os.Stderr, "%s: SoC %.0f%% already at the limit of %.0f%%\n", b.Name, s.USOC, floor)
+		return 1
+	}
+	audited := newCliAudit(conf.Audit, b.Name, "gok discharge", s.USOC)
+	err = api.SwitchOperatingModeToManual(s.OperatingMode)
+	audited.record(audit.ActionManual, 0, err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: switching to manual mode: %s\n", b.Name, err)
+		return 1
+	}
+	// from here on the battery is in manual mode and must be switched back on every exit
+	restoreAuto := func() int {
+		err := api.SwitchOperatingModeToAuto("")
+		audited.record(audit.ActionAuto, 0, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: switching to automatic mode: %s\n", b.Name, err)
+			return 1
+		}
+		return 0
+	}
+	err = api.StartDischarge(*power)
+	audited.record(audit.ActionStart, *power, err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: starting discharge: %s\n", b.Name, err)
+		restoreAuto()
+		return 1
+	}
+
+	var deadline <-chan time.Time
+	if *duration > 0 {
+		deadline = time.After(*duration)
+		fmt.Printf("%s: discharging with %d W at %.0f%% SoC until %s or %.0f%% SoC, interrupt to stop early\n",
+			b.Name, *power, s.USOC, time.Now().Add(*duration).Format(time.TimeOnly), floor)
+	} else {
+		fmt.Printf("%s: discharging with %d W at %.0f%% SoC until %.0f%% SoC, interrupt to stop early\n",
+			b.Name, *power, s.USOC, floor)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(b.PollInterval)
+	defer ticker.Stop()
+
+	failed := 0
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("interrupted")
+			break wait
+		case <-deadline:
+			break wait
+		case <-ticker.C:
+			s, err := api.Status()
+			if err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: %s\n", b.Name, err)
+				if failed >= maxFailedPolls {
+					fmt.Fprintf(os.Stderr, "%s: SoC unknown after %d failed polls, stopping\n", b.Name, failed)
+					break wait
+				}
+				continue
+			}
+			failed = 0
+			if s.OperatingMode != "1" {
+				fmt.Fprintf(os.Stderr, "%s: switched to %s by another client, discharge ended\n",
+					b.Name, operatingMode(s.OperatingMode))
+				return 1
+			}
+			if s.USOC <= floor {
+				fmt.Printf("%s: SoC limit of %.0f%% reached\n", b.Name, floor)
+				break wait
+			}
+		}
+	}
+
+	code := 0
+	err = api.StopDischarge()
+	audited.record(audit.ActionStop, 0, err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: stopping discharge: %s\n", b.Name, err)
+		code = 1
+	}
+	if restoreAuto() != 0 {
+		return 1
+	}
+	fmt.Printf("%s: discharge stopped, automatic mode restored\n", b.Name)
+	return code
+}
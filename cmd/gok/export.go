@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gok-pi/battery/recorder"
+	"gok-pi/internal/config"
+	"os"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// export dumps recorded statuses of the given period as CSV or JSON lines.
+// The JSON lines output can be replayed by the simulate command.
+func export(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("conf", "config.yml", "path to config file")
+	dir := fs.String("dir", "", "history directory, defaults to recorder.path from the config")
+	from := fs.String("from", "", "start of the period, date or RFC 3339 time (default: 24 hours ago)")
+	to := fs.String("to", "", "end of the period, exclusive, date or RFC 3339 time (default: now)")
+	battery := fs.String("battery", "", "battery name, all batteries if empty")
+	format := fs.String("format", "csv", "output format: csv or jsonl")
+	_ = fs.Parse(args)
+
+	if *dir == "" {
+		conf, err := config.Read(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *configPath, err)
+			return 1
+		}
+		*dir = conf.Recorder.Path
+	}
+
+	now := time.Now()
+	toTime, err := parseTimeArg(*to, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -to: %s\n", err)
+		return 2
+	}
+	fromTime, err := parseTimeArg(*from, toTime.Add(-24*time.Hour))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -from: %s\n", err)
+		return 2
+	}
+
+	batteries := []string{*battery}
+	if *battery == "" {
+		batteries, err = recorder.Batteries(*dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	var write func(recorder.Record) error
+	switch *format {
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+		header := false
+		write = func(r recorder.Record) error {
+			if !header {
+				header = true
+				if err := w.Write(append([]string{"battery"}, recorder.Header()...)); err != nil {
+					return err
+				}
+			}
+			return w.Write(append([]string{r.Battery}, recorder.Row(r)...))
+		}
+	case "jsonl":
+		enc := json.NewEncoder(os.Stdout)
+		write = func(r recorder.Record) error {
+			return enc.Encode(r)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected csv or jsonl\n", *format)
+		return 2
+	}
+
+	for _, b := range batteries {
+		err = recorder.Read(*dir, b, fromTime, toTime, write)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseTimeArg parses a date in local time or an RFC 3339 time; empty values yield def.
+func parseTimeArg(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if t, err := time.ParseInLocation(dateLayout, value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gok-pi/battery/audit"
+	"os"
+)
+
+// mode switches the operating mode of a battery to auto or manual.
+func mode(args []string) int {
+	fs := flag.NewFlagSet("mode", flag.ExitOnError)
+	configPath := fs.String("conf", "config.yml", "path to config file")
+	name := fs.String("battery", "", "battery name, may be omitted if only one battery is enabled")
+	verbose := fs.Bool("v", false, "log API requests")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gok mode [flags] auto|manual")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	target := fs.Arg(0)
+	if target != "auto" && target != "manual" {
+		fs.Usage()
+		return 2
+	}
+
+	conf, b, err := selectBattery(*configPath, *name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	api := newApiClient(b, cliLogger(*verbose))
+	s, err := api.Status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", b.Name, err)
+		return 1
+	}
+	audited := newCliAudit(conf.Audit, b.Name, "gok mode "+target, s.USOC)
+	if target == "auto" {
+		err = api.SwitchOperatingModeToAuto(s.OperatingMode)
+		audited.record(audit.ActionAuto, 0, err)
+	} else {
+		err = api.SwitchOperatingModeToManual(s.OperatingMode)
+		audited.record(audit.ActionManual, 0, err)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: switching to %s mode: %s\n", b.Name, target, err)
+		return 1
+	}
+	fmt.Printf("%s: %s -> %s\n", b.Name, operatingMode(s.OperatingMode), target)
+	return 0
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gok-pi/battery/entity"
+	"gok-pi/battery/strategy"
+	"gok-pi/internal/config"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// planCells is the number of cells per day in the ASCII timeline, 30 minutes each.
+	planCells = 48
+	planCell  = 24 * time.Hour / planCells
+)
+
+// planEntry is a schedule period in the JSON output.
+type planEntry struct {
+	Battery    string    `json:"battery"`
+	Schedule   string    `json:"schedule"`
+	Start      time.Time `json:"start"`
+	Stop       time.Time `json:"stop"`
+	PowerLimit int       `json:"power_limit"`
+	SocLimit   int       `json:"soc_limit"`
+	Priority   int       `json:"priority"`
+}
+
+// plan prints the upcoming schedule periods of a battery, evaluated with the same code
+// as the daemon, as an ASCII timeline or JSON.
+func plan(args []string) int {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	configPath := fs.String("conf", "config.yml", "path to config file")
+	name := fs.String("battery", "", "battery name, may be omitted if only one battery is enabled")
+	days := fs.Int("days", 7, "number of days to plan")
+	from := fs.String("from", "", "start of the plan, date or RFC 3339 time (default: now)")
+	format := fs.String("format", "ascii", "output format: ascii or json")
+	_ = fs.Parse(args)
+
+	if *days < 1 {
+		fmt.Fprintln(os.Stderr, "invalid -days, must be at least 1")
+		return 2
+	}
+	start, err := parseTimeArg(*from, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -from: %s\n", err)
+		return 2
+	}
+	// schedules are evaluated in local time like in the daemon
+	start = start.Local()
+
+	// secrets are not needed for planning, so the config is not fully loaded
+	conf, err := config.Read(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *configPath, err)
+		return 1
+	}
+	batteries, err := pickBatteries(conf, *name)
+	if err == nil && len(batteries) > 1 {
+		err = fmt.Errorf("%d batteries enabled, select one with -battery", len(batteries))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	b := batteries[0]
+
+	set, errs := strategy.NewSchedules(batterySchedules(conf, b.Name))
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+	end := start.AddDate(0, 0, *days)
+	periods := set.Plan(start, end)
+
+	switch *format {
+	case "ascii":
+		printPlan(b, periods, start, end)
+	case "json":
+		entries := make([]planEntry, 0, len(periods))
+		for _, p := range periods {
+			if p.Schedule == nil {
+				continue
+			}
+			entries = append(entries, planEntry{
+				Battery:    b.Name,
+				Schedule:   p.Schedule.Label(),
+				Start:      p.Start,
+				Stop:       p.Stop,
+				PowerLimit: p.Schedule.PowerLimit,
+				SocLimit:   p.Schedule.SocLimit,
+				Priority:   p.Schedule.Priority,
+			})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(entries)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected ascii or json\n", *format)
+		return 2
+	}
+	return 0
+}
+
+// printPlan prints a day by day timeline with one cell per 30 minutes, followed by
+// the list of periods.
+func printPlan(b config.BatteryConfig, periods []strategy.Period, start, end time.Time) {
+	fmt.Printf("%s from %s to %s\n\n", b.Name, start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"))
+
+	// each schedule is drawn with its own letter, in order of appearance
+	marks := make(map[string]byte)
+	var legend []string
+	for _, p := range periods {
+		if p.Schedule == nil {
+			continue
+		}
+		label := p.Schedule.Label()
+		if _, ok := marks[label]; !ok {
+			marks[label] = byte('A' + len(marks)%26)
+			legend = append(legend, fmt.Sprintf("%c = %s", marks[label], label))
+		}
+	}
+
+	fmt.Printf("%-17s", "")
+	for h := 0; h < 24; h += 3 {
+		fmt.Printf("%-6s", fmt.Sprintf("%02d", h))
+	}
+	fmt.Println()
+	y, m, d := start.Date()
+	for day := time.Date(y, m, d, 0, 0, 0, 0, start.Location()); day.Before(end); day = day.AddDate(0, 0, 1) {
+		row := make([]byte, planCells)
+		for i := range row {
+			t := day.Add(time.Duration(i) * planCell)
+			row[i] = '.'
+			if t.Add(planCell).After(start) && t.Before(end) {
+				if s := scheduleAt(periods, t); s != nil {
+					row[i] = marks[s.Label()]
+				}
+			} else {
+				row[i] = ' '
+			}
+		}
+		fmt.Printf("%s  |%s|\n", day.Format("Mon 2006-01-02"), row)
+	}
+	if len(legend) > 0 {
+		fmt.Printf("\n%s\n", strings.Join(legend, ", "))
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "START\tSTOP\tSCHEDULE\tPOWER\tSOC LIMIT\tPRIORITY")
+	for _, p := range periods {
+		if p.Schedule == nil {
+			continue
+		}
+		stopLayout := "15:04"
+		if !sameDay(p.Start, p.Stop) {
+			stopLayout = "Mon 2006-01-02 15:04"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d W\t%d%%\t%d\n",
+			p.Start.Format("Mon 2006-01-02 15:04"), p.Stop.Format(stopLayout),
+			p.Schedule.Label(), p.Schedule.PowerLimit, p.Schedule.SocLimit, p.Schedule.Priority)
+	}
+	_ = w.Flush()
+	if b.Forecast.Enabled {
+		fmt.Println("\nSoC limits may be raised at runtime by the PV forecast")
+	}
+}
+
+// scheduleAt returns the schedule of the period containing t.
+func scheduleAt(periods []strategy.Period, t time.Time) *entity.Schedule {
+	for _, p := range periods {
+		if !t.Before(p.Start) && t.Before(p.Stop) {
+			return p.Schedule
+		}
+	}
+	return nil
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
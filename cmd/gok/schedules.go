@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gok-pi/battery/entity"
+	"gok-pi/battery/strategy"
+	"gok-pi/internal/config"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// schedules lists the configured schedules, marking the one in effect now for each battery.
+func schedules(args []string) int {
+	fs := flag.NewFlagSet("schedules", flag.ExitOnError)
+	configPath := fs.String("conf", "config.yml", "path to config file")
+	name := fs.String("battery", "", "battery name, all batteries if empty")
+	_ = fs.Parse(args)
+
+	conf, err := config.Read(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *configPath, err)
+		return 1
+	}
+
+	byBattery := make(map[string][]entity.Schedule)
+	for _, s := range conf.Schedules {
+		byBattery[s.BatteryName] = append(byBattery[s.BatteryName], s)
+	}
+
+	now := time.Now()
+	var next []string
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BATTERY\tSCHEDULE\tWINDOW\tENABLED\tPRIORITY\tPOWER\tSOC LIMIT\tACTIVE")
+	for _, b := range conf.Batteries {
+		if *name != "" && b.Name != *name {
+			continue
+		}
+		set, _ := strategy.NewSchedules(byBattery[b.Name])
+		active := set.Active(now)
+		for _, s := range byBattery[b.Name] {
+			mark := ""
+			if active != nil && active.Label() == s.Label() {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s-%s\t%t\t%d\t%d W\t%d%%\t%s\n",
+				b.Name, s.Label(), s.StartTime, s.StopTime, s.Enabled, s.Priority, s.PowerLimit, s.SocLimit, mark)
+		}
+		if t, ok := set.NextBoundary(now); ok {
+			next = append(next, fmt.Sprintf("%s: next change at %s", b.Name, t.Format("2006-01-02 15:04")))
+		}
+	}
+	_ = w.Flush()
+	if len(next) > 0 {
+		fmt.Println()
+	}
+	for _, line := range next {
+		fmt.Println(line)
+	}
+	return 0
+}
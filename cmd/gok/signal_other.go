@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "gok-pi/internal/lib/logger"
+
+// watchLogLevel does nothing where SIGUSR1 is not available.
+func watchLogLevel(*logger.Logger) {}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"gok-pi/internal/lib/logger"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLogLevel toggles between debug and the configured log level on SIGUSR1.
+func watchLogLevel(service *logger.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			level := service.ToggleDebug()
+			service.Warn("log level changed", slog.String("level", level.String()))
+		}
+	}()
+}
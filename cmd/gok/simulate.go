@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gok-pi/battery/recorder"
+	"gok-pi/battery/simulator"
+	"gok-pi/battery/strategy"
+	"gok-pi/internal/config"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// simulate replays a recorded status history, as written by "gok export -format jsonl",
+// through the discharge worker with a modelled battery for every strategy and compares
+// the energy flows and cost with the uncontrolled baseline.
+func simulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configPath := fs.String("conf", "config.yml", "path to config file")
+	history := fs.String("history", "", "status history in JSON lines, \"-\" for stdin")
+	battery := fs.String("battery", "", "battery name, all batteries of the history if empty")
+	strategies := fs.String("strategies", "", "comma separated strategies (default: the configured strategy)")
+	capacity := fs.Float64("capacity", 0, "battery capacity in Wh (default: derived from the first sample)")
+	maxPower := fs.Float64("max-power", 2500, "battery charge and discharge power in automatic mode, W")
+	importPrice := fs.Float64("price", 0.30, "grid import price per kWh")
+	exportPrice := fs.Float64("feed-in-price", 0.08, "grid feed in revenue per kWh")
+	verbose := fs.Bool("v", false, "log the worker decisions")
+	_ = fs.Parse(args)
+
+	if *history == "" {
+		fmt.Fprintln(os.Stderr, "-history is required")
+		return 2
+	}
+	conf, err := config.Read(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *configPath, err)
+		return 1
+	}
+	records, err := readHistory(*history)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if *verbose {
+		log = cliLogger(true)
+	}
+
+	names := make([]string, 0, len(records))
+	for name := range records {
+		if *battery == "" || name == *battery {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "no matching records in history")
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BATTERY\tSTRATEGY\tDISCHARGED kWh\tCONTROLLED kWh\tCHARGED kWh\tGRID IMPORT kWh\tGRID EXPORT kWh\tCOST")
+	code := 0
+	for _, name := range names {
+		batteries, err := pickBatteries(conf, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+			code = 1
+			continue
+		}
+		b := batteries[0]
+		opts := simulator.Options{
+			CapacityWh:  *capacity,
+			MaxPower:    *maxPower,
+			PowerLimit:  b.PowerLimit,
+			SocLimit:    b.SocLimit,
+			ImportPrice: *importPrice,
+			ExportPrice: *exportPrice,
+		}
+
+		labels := []string{simulator.Baseline}
+		if *strategies == "" {
+			labels = append(labels, b.Strategy)
+		} else {
+			labels = append(labels, strings.Split(*strategies, ",")...)
+		}
+		for _, label := range labels {
+			var st strategy.Strategy
+			if label != simulator.Baseline {
+				// forecasts are not replayed, the schedule SoC limits apply as configured
+				st, err = strategy.New(label, strategy.Options{Schedules: batterySchedules(conf, name)}, log)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+					code = 1
+					continue
+				}
+			}
+			r, err := simulator.Run(records[name], name, label, st, opts, log.With(slog.String("battery", name)))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+				code = 1
+				break
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\n", r.Battery, r.Strategy,
+				r.DischargedWh/1000, r.ControlledWh/1000, r.ChargedWh/1000, r.GridImportWh/1000, r.GridExportWh/1000, r.Cost)
+		}
+	}
+	_ = w.Flush()
+	return code
+}
+
+// readHistory reads JSON lines records and groups them by battery, ordered by time.
+func readHistory(path string) (map[string][]recorder.Record, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening history: %w", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r = f
+	}
+
+	records := make(map[string][]recorder.Record)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record recorder.Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("history line %d: %w", line, err)
+		}
+		if record.Status == nil {
+			return nil, fmt.Errorf("history line %d: missing status", line)
+		}
+		records[record.Battery] = append(records[record.Battery], record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	for _, rs := range records {
+		sort.SliceStable(rs, func(i, j int) bool {
+			return rs[i].Time.Before(rs[j].Time)
+		})
+	}
+	return records, nil
+}
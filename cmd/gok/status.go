@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// status prints the current status of one battery, or of all enabled batteries.
+func status(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath := fs.String("conf", "config.yml", "path to config file")
+	asJson := fs.Bool("json", false, "print the raw status as JSON")
+	verbose := fs.Bool("v", false, "log API requests")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gok status [flags] [battery]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	batteries, err := selectBatteries(*configPath, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	log := cliLogger(*verbose)
+	code := 0
+	for _, b := range batteries {
+		s, err := newApiClient(b, log).Status()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", b.Name, err)
+			code = 1
+			continue
+		}
+		if *asJson {
+			_ = json.NewEncoder(os.Stdout).Encode(map[string]any{"battery": b.Name, "status": s})
+			continue
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, b.Name)
+		fmt.Fprintf(w, "  operating mode\t%s\n", operatingMode(s.OperatingMode))
+		fmt.Fprintf(w, "  state of charge\t%.0f%% (usable %.0f%%)\n", s.RSOC, s.USOC)
+		fmt.Fprintf(w, "  remaining capacity\t%.0f Wh\n", s.RemainingCapacityWh)
+		fmt.Fprintf(w, "  battery power\t%.0f W\n", s.PacTotalW)
+		fmt.Fprintf(w, "  charging / discharging\t%t / %t\n", s.BatteryCharging, s.BatteryDischarging)
+		fmt.Fprintf(w, "  production\t%.0f W\n", s.ProductionW)
+		fmt.Fprintf(w, "  consumption\t%.0f W\n", s.ConsumptionW)
+		fmt.Fprintf(w, "  grid feed in\t%.0f W\n", s.GridFeedInW)
+		fmt.Fprintf(w, "  system status\t%s\n", s.SystemStatus)
+		fmt.Fprintf(w, "  timestamp\t%s\n", s.Timestamp)
+		_ = w.Flush()
+	}
+	return code
+}
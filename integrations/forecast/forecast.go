@@ -0,0 +1,226 @@
+package forecast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gok-pi/internal/lib/clock"
+	"gok-pi/internal/lib/sl"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const dayLayout = "2006-01-02"
+
+var httpClient = &http.Client{}
+
+// Provider returns the expected PV production in Wh for the given day. Production is called
+// on every strategy decision and must not block: it returns a cached estimate, which the
+// provider refreshes in the background.
+type Provider interface {
+	Production(day time.Time) (float64, error)
+}
+
+// estimate is the subset of the forecast.solar response used by the service:
+//
+//	{"result": {"watt_hours_day": {"2024-08-14": 12345, "2024-08-15": 9876}}}
+type estimate struct {
+	Result struct {
+		WattHoursDay map[string]float64 `json:"watt_hours_day"`
+	} `json:"result"`
+}
+
+func parseEstimate(body []byte) (*estimate, error) {
+	var e estimate
+	err := json.Unmarshal(body, &e)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal forecast body: %w", err)
+	}
+	return &e, nil
+}
+
+func (e *estimate) production(day time.Time) (float64, error) {
+	key := day.Format(dayLayout)
+	wh, ok := e.Result.WattHoursDay[key]
+	if !ok {
+		return 0, fmt.Errorf("no forecast for %s", key)
+	}
+	return wh, nil
+}
+
+// retryDelay is the least time between failed refreshes, so that an unreachable or rate
+// limiting source is not requested on every evaluation.
+const retryDelay = 10 * time.Minute
+
+// cache keeps the last estimate of a source for the refresh interval and refreshes it in
+// the background. Production only reads the cache and returns an error until the first
+// estimate has been received.
+type cache struct {
+	fetch     func() (*estimate, error)
+	refresh   time.Duration
+	clock     clock.Clock
+	mutex     sync.Mutex
+	cached    *estimate
+	cachedAt  time.Time
+	attempted time.Time
+	fetching  bool
+	log       *slog.Logger
+}
+
+// SetClock replaces the wall clock used for the refresh and retry intervals.
+func (c *cache) SetClock(clock clock.Clock) {
+	c.clock = clock
+}
+
+func (c *cache) Production(day time.Time) (float64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now := c.clock.Now(); !c.fetching && c.due(now) {
+		c.fetching = true
+		c.attempted = now
+		go c.update()
+	}
+	if c.cached == nil {
+		return 0, errors.New("no forecast received yet")
+	}
+	return c.cached.production(day)
+}
+
+// due reports whether the cached estimate is outdated and no failed refresh was made
+// within the retry delay. Must be called with the mutex held.
+func (c *cache) due(now time.Time) bool {
+	if c.cached != nil && now.Sub(c.cachedAt) < c.refresh {
+		return false
+	}
+	return c.attempted.IsZero() || now.Sub(c.attempted) >= min(retryDelay, c.refresh)
+}
+
+// update fetches the estimate and replaces the cached one on success.
+func (c *cache) update() {
+	e, err := c.fetch()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.fetching = false
+	if err != nil {
+		log := c.log.With(sl.Err(err))
+		if c.cached != nil {
+			log.Warn("updating forecast, using cached forecast")
+		} else {
+			log.Error("updating forecast")
+		}
+		return
+	}
+	c.cached = e
+	c.cachedAt = c.clock.Now()
+}
+
+// File reads a forecast.solar compatible estimate from a local JSON file. The file is
+// read once on creation and then every refresh interval, so an external job may replace
+// it at any time.
+type File struct {
+	path string
+	cache
+}
+
+func NewFile(path string, refresh time.Duration, log *slog.Logger) *File {
+	f := &File{path: path}
+	f.cache = cache{
+		fetch:   f.read,
+		refresh: refresh,
+		clock:   clock.Real{},
+		log:     log.With(sl.Module("forecast")),
+	}
+	// a local file is read right away, so that the first decision has a forecast
+	f.attempted = f.clock.Now()
+	f.fetching = true
+	f.update()
+	return f
+}
+
+func (f *File) read() (*estimate, error) {
+	body, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading forecast file: %w", err)
+	}
+	return parseEstimate(body)
+}
+
+// Http requests an estimate from a forecast.solar compatible endpoint.
+// Responses are cached for the refresh interval to stay within the public API rate limits.
+type Http struct {
+	url string
+	cache
+}
+
+func NewHttp(url string, refresh time.Duration, log *slog.Logger) *Http {
+	h := &Http{url: url}
+	h.cache = cache{
+		fetch:   h.request,
+		refresh: refresh,
+		clock:   clock.Real{},
+		log:     log.With(sl.Module("forecast")),
+	}
+	return h
+}
+
+func (h *Http) request() (*estimate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("request timeout")
+		}
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("received status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	h.log.With(slog.String("url", h.url)).Debug("forecast updated")
+	return parseEstimate(body)
+}
+
+// NextProductionDay returns the day whose production will refill the battery after
+// an evening discharge: today before noon (the discharge window ran past midnight),
+// tomorrow otherwise.
+func NextProductionDay(now time.Time) time.Time {
+	if now.Hour() < 12 {
+		return now
+	}
+	return now.AddDate(0, 0, 1)
+}
+
+// SocLimit raises the schedule SoC limit when the expected production is below minProduction.
+// The limit grows linearly from the schedule value at minProduction to maxSoc at zero production.
+func SocLimit(scheduleSoc, maxSoc, production, minProduction float64) float64 {
+	if minProduction <= 0 || production >= minProduction || maxSoc <= scheduleSoc {
+		return scheduleSoc
+	}
+	if production < 0 {
+		production = 0
+	}
+	return scheduleSoc + (maxSoc-scheduleSoc)*(1-production/minProduction)
+}
@@ -0,0 +1,106 @@
+package forecast
+
+import (
+	"gok-pi/internal/lib/clock"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHttpBacksOffAfterFailure(t *testing.T) {
+	var requests atomic.Int32
+	failing := atomic.Bool{}
+	failing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = io.WriteString(w, `{"result": {"watt_hours_day": {"2024-08-15": 9876}}}`)
+	}))
+	defer server.Close()
+
+	clk := clock.NewFake(time.Date(2024, 8, 14, 20, 0, 0, 0, time.UTC))
+	h := NewHttp(server.URL, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.SetClock(clk)
+	day := time.Date(2024, 8, 15, 0, 0, 0, 0, time.UTC)
+
+	// every evaluation within the retry delay reads the cache only
+	for i := 0; i < 5; i++ {
+		if _, err := h.Production(day); err == nil {
+			t.Fatal("production without a forecast, want error")
+		}
+		waitFetched(t, &h.cache)
+		clk.Advance(10 * time.Second)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+
+	failing.Store(false)
+	clk.Advance(retryDelay)
+	_, _ = h.Production(day)
+	waitFetched(t, &h.cache)
+	wh, err := h.Production(day)
+	if err != nil || wh != 9876 {
+		t.Errorf("production = %v, %v, want 9876", wh, err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+}
+
+func TestFileReadsEveryRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forecast.json")
+	write := func(wh string) {
+		t.Helper()
+		body := `{"result": {"watt_hours_day": {"2024-08-15": ` + wh + `}}}`
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("9876")
+
+	// the file is read on creation with the wall clock
+	f := NewFile(path, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	clk := clock.NewFake(time.Now())
+	f.SetClock(clk)
+	day := time.Date(2024, 8, 15, 0, 0, 0, 0, time.UTC)
+
+	// the file is read on creation, replacing it has no effect until the refresh
+	write("1234")
+	if wh, err := f.Production(day); err != nil || wh != 9876 {
+		t.Errorf("production = %v, %v, want 9876", wh, err)
+	}
+
+	clk.Advance(time.Hour)
+	_, _ = f.Production(day)
+	waitFetched(t, &f.cache)
+	if wh, err := f.Production(day); err != nil || wh != 1234 {
+		t.Errorf("production after refresh = %v, %v, want 1234", wh, err)
+	}
+}
+
+func waitFetched(t *testing.T, c *cache) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mutex.Lock()
+		fetching := c.fetching
+		c.mutex.Unlock()
+		if !fetching {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the forecast request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
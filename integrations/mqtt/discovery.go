@@ -0,0 +1,161 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"gok-pi/internal/lib/sl"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+var unsafeId = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// sensor is a numeric field of the published SystemStatus.
+type sensor struct {
+	key         string
+	name        string
+	field       string
+	unit        string
+	deviceClass string
+}
+
+var sensors = []sensor{
+	{key: "rsoc", name: "Relative SoC", field: "RSOC", unit: "%", deviceClass: "battery"},
+	{key: "usoc", name: "User SoC", field: "USOC", unit: "%", deviceClass: "battery"},
+	{key: "remaining_capacity", name: "Remaining capacity", field: "RemainingCapacity_Wh", unit: "Wh", deviceClass: "energy_storage"},
+	{key: "consumption", name: "Consumption", field: "Consumption_W", unit: "W", deviceClass: "power"},
+	{key: "ac_power", name: "AC power", field: "Pac_total_W", unit: "W", deviceClass: "power"},
+	{key: "production", name: "Production", field: "Production_W", unit: "W", deviceClass: "power"},
+	{key: "grid_feed_in", name: "Grid feed in", field: "GridFeedIn_W", unit: "W", deviceClass: "power"},
+}
+
+// publishDiscovery announces the battery's entities to Home Assistant:
+// status sensors, the control state, a discharging binary sensor, discharge and pause switches,
+// a SoC limit number and one switch per schedule.
+func (c *Client) publishDiscovery(name string) {
+	if !c.conf.Discovery.Enabled {
+		return
+	}
+	c.mutex.Lock()
+	b, ok := c.batteries[name]
+	c.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	node := "gok_pi_" + objectId(name)
+	device := map[string]any{
+		"identifiers":  []string{node},
+		"name":         "gok-pi " + name,
+		"manufacturer": "sonnen",
+		"model":        "gok-pi",
+	}
+	statusTopic := c.Topic(c.conf.StatusTopic, name)
+	stateTopic := c.Topic(c.conf.StateTopic, name)
+	commandTopic := c.Topic(c.conf.CommandTopic, name)
+
+	announce := func(component, object string, entity map[string]any) {
+		entity["unique_id"] = node + "_" + object
+		entity["object_id"] = node + "_" + object
+		entity["device"] = device
+		entity["availability_topic"] = c.conf.AvailabilityTopic
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", c.conf.Discovery.Prefix, component, node, object)
+		payload, err := json.Marshal(entity)
+		if err != nil {
+			c.log.With(sl.Err(err), slog.String("topic", topic)).Error("marshalling discovery")
+			return
+		}
+		c.publish(topic, payload, true)
+	}
+
+	for _, s := range sensors {
+		announce("sensor", s.key, map[string]any{
+			"name":                s.name,
+			"state_topic":         statusTopic,
+			"value_template":      fmt.Sprintf("{{ value_json.%s }}", s.field),
+			"unit_of_measurement": s.unit,
+			"device_class":        s.deviceClass,
+			"state_class":         "measurement",
+		})
+	}
+
+	announce("sensor", "control_state", map[string]any{
+		"name":           "Control state",
+		"state_topic":    stateTopic,
+		"value_template": "{{ value_json.control }}",
+		"icon":           "mdi:state-machine",
+	})
+	announce("binary_sensor", "discharging", map[string]any{
+		"name":           "Discharging",
+		"state_topic":    stateTopic,
+		"value_template": "{{ 'ON' if value_json.discharging else 'OFF' }}",
+		"device_class":   "running",
+	})
+	announce("switch", "discharge", map[string]any{
+		"name":           "Discharge",
+		"state_topic":    stateTopic,
+		"value_template": "{{ 'ON' if value_json.discharging else 'OFF' }}",
+		"command_topic":  commandTopic + "/discharge_switch",
+		"icon":           "mdi:battery-arrow-down",
+	})
+	announce("switch", "pause", map[string]any{
+		"name":           "Pause control",
+		"state_topic":    stateTopic,
+		"value_template": "{{ 'ON' if value_json.paused else 'OFF' }}",
+		"command_topic":  commandTopic + "/pause",
+		"icon":           "mdi:pause-circle",
+	})
+	announce("number", "soc_limit", map[string]any{
+		"name":                "SoC limit",
+		"state_topic":         stateTopic,
+		"value_template":      "{{ value_json.soc_limit | default(0) }}",
+		"command_topic":       commandTopic + "/soc_limit",
+		"min":                 0,
+		"max":                 100,
+		"step":                1,
+		"unit_of_measurement": "%",
+		"mode":                "box",
+	})
+	for _, label := range b.schedules {
+		quoted := strings.ReplaceAll(label, "'", "\\'")
+		announce("switch", "schedule_"+objectId(label), map[string]any{
+			"name":           "Schedule " + label,
+			"state_topic":    stateTopic,
+			"value_template": fmt.Sprintf("{{ 'ON' if value_json.schedules is defined and value_json.schedules.get('%s') else 'OFF' }}", quoted),
+			"command_topic":  commandTopic + "/schedule/" + objectId(label),
+			"icon":           "mdi:calendar-clock",
+		})
+	}
+	c.log.With(slog.String("battery", name)).Debug("published discovery")
+}
+
+// subscribeDiscoveryStatus republishes discovery when Home Assistant comes online,
+// since it forgets non-retained entities on restart.
+func (c *Client) subscribeDiscoveryStatus() {
+	if !c.conf.Discovery.Enabled {
+		return
+	}
+	topic := c.conf.Discovery.Prefix + "/status"
+	token := c.client.Subscribe(topic, byte(c.conf.Qos), func(_ paho.Client, msg paho.Message) {
+		if string(msg.Payload()) != availabilityOnline {
+			return
+		}
+		for _, name := range c.batteryNames() {
+			c.publishDiscovery(name)
+		}
+	})
+	log := c.log.With(slog.String("topic", topic))
+	if !token.WaitTimeout(publishTimeout) {
+		log.Warn("subscribing to discovery status timed out")
+		return
+	}
+	if err := token.Error(); err != nil {
+		log.With(sl.Err(err)).Error("subscribing to discovery status")
+	}
+}
+
+func objectId(s string) string {
+	return strings.Trim(unsafeId.ReplaceAllString(strings.ToLower(s), "_"), "_")
+}
@@ -0,0 +1,366 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"gok-pi/battery/discharger"
+	"gok-pi/battery/entity"
+	"gok-pi/internal/config"
+	"gok-pi/internal/lib/sl"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	batteryPlaceholder = "{battery}"
+	connectTimeout     = 10 * time.Second
+	publishTimeout     = 2 * time.Second
+	availabilityOnline = "online"
+	availabilityOff    = "offline"
+	publishQueueSize   = 64
+)
+
+// commandSource identifies MQTT commands in the audit log.
+const commandSource = "mqtt"
+
+// Controller is the part of the discharge worker driven by MQTT commands. The source
+// passed to each command names who requested it.
+type Controller interface {
+	ForceDischarge(source string, power int, duration time.Duration) error
+	CancelDischarge(source string) error
+	Pause(source string, paused bool) error
+	SetSocLimit(source string, limit float64) error
+	EnableSchedule(source, label string, enabled bool) error
+}
+
+// message is a publish waiting in the queue.
+type message struct {
+	topic    string
+	payload  any
+	retained bool
+}
+
+type battery struct {
+	controller Controller
+	schedules  []string
+}
+
+// schedule returns the label of the schedule given by its label or its object id, the
+// topic safe form used in discovery, e.g. 20_00-22_00 for 20:00-22:00.
+func (b battery) schedule(id string) (string, bool) {
+	for _, label := range b.schedules {
+		if label == id || objectId(label) == id {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// Client publishes battery status and worker state and forwards commands received on
+// <command_topic>/discharge, /discharge_switch, /cancel, /pause, /soc_limit and
+// /schedule/<label or object id> to the battery's controller. The log level may be
+// changed on /log_level of any battery. It implements discharger.StatePublisher.
+type Client struct {
+	conf      config.Mqtt
+	client    paho.Client
+	mutex     sync.Mutex
+	batteries map[string]battery
+	setLevel  func(level string) error
+	queue     chan message
+	done      chan struct{}
+	log       *slog.Logger
+}
+
+func New(conf config.Mqtt, log *slog.Logger) *Client {
+	c := &Client{
+		conf:      conf,
+		batteries: make(map[string]battery),
+		queue:     make(chan message, publishQueueSize),
+		done:      make(chan struct{}),
+		log:       log.With(sl.Module("mqtt")),
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(conf.Broker).
+		SetClientID(conf.ClientId).
+		SetUsername(conf.Username).
+		SetPassword(conf.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false).
+		SetWriteTimeout(publishTimeout).
+		SetWill(conf.AvailabilityTopic, availabilityOff, byte(conf.Qos), true).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			c.log.With(sl.Err(err)).Warn("connection lost")
+		})
+	c.client = paho.NewClient(opts)
+	go c.runPublisher()
+	return c
+}
+
+// Connect starts connecting to the broker. With connect retry enabled the client keeps
+// trying in the background, so only a timeout of the first attempt is reported.
+func (c *Client) Connect() error {
+	c.log.With(slog.String("broker", c.conf.Broker)).Info("connecting to broker")
+	token := c.client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		c.log.Warn("broker not reachable yet, retrying in background")
+		return nil
+	}
+	return token.Error()
+}
+
+// Close stops publishing, publishes the offline availability and disconnects.
+// Queued messages are dropped.
+func (c *Client) Close() {
+	close(c.done)
+	c.send(message{topic: c.conf.AvailabilityTopic, payload: availabilityOff, retained: true})
+	c.client.Disconnect(uint(publishTimeout.Milliseconds()))
+}
+
+// SetLogLevel enables the log_level command, changing the service log level to
+// debug, info, warn or error.
+func (c *Client) SetLogLevel(setLevel func(level string) error) {
+	c.setLevel = setLevel
+}
+
+// AddBattery subscribes to the command topics of the battery and announces it for
+// discovery with its schedule labels. It may be called before or after Connect;
+// subscriptions and discovery are renewed on every reconnect.
+func (c *Client) AddBattery(name string, controller Controller, schedules []string) {
+	c.mutex.Lock()
+	c.batteries[name] = battery{controller: controller, schedules: schedules}
+	c.mutex.Unlock()
+
+	if c.client.IsConnectionOpen() {
+		c.subscribe(name)
+		c.publishDiscovery(name)
+	}
+}
+
+func (c *Client) PublishStatus(name string, status *entity.SystemStatus) {
+	c.publishJSON(c.Topic(c.conf.StatusTopic, name), status)
+}
+
+func (c *Client) PublishState(name string, state discharger.State) {
+	c.publishJSON(c.Topic(c.conf.StateTopic, name), state)
+}
+
+// Topic returns the topic template with the battery name filled in.
+func (c *Client) Topic(template, battery string) string {
+	return strings.ReplaceAll(template, batteryPlaceholder, battery)
+}
+
+// Publish sends a payload to an arbitrary topic, e.g. for discovery messages.
+func (c *Client) Publish(topic string, payload []byte, retained bool) {
+	c.publish(topic, payload, retained)
+}
+
+func (c *Client) onConnect(_ paho.Client) {
+	c.log.Info("connected to broker")
+	c.publish(c.conf.AvailabilityTopic, availabilityOnline, true)
+
+	c.subscribeDiscoveryStatus()
+	for _, name := range c.batteryNames() {
+		c.subscribe(name)
+		c.publishDiscovery(name)
+	}
+}
+
+func (c *Client) batteryNames() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	names := make([]string, 0, len(c.batteries))
+	for name := range c.batteries {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (c *Client) subscribe(name string) {
+	prefix := c.Topic(c.conf.CommandTopic, name) + "/"
+	topic := prefix + "#"
+	token := c.client.Subscribe(topic, byte(c.conf.Qos), func(_ paho.Client, msg paho.Message) {
+		c.handleCommand(name, strings.TrimPrefix(msg.Topic(), prefix), msg.Payload())
+	})
+	log := c.log.With(slog.String("topic", topic))
+	if !token.WaitTimeout(publishTimeout) {
+		log.Warn("subscribing to commands timed out, commands may not be received until reconnected")
+		return
+	}
+	if err := token.Error(); err != nil {
+		log.With(sl.Err(err)).Error("subscribing to commands")
+		return
+	}
+	log.Debug("subscribed to commands")
+}
+
+// dischargeCommand is the JSON payload of the discharge command. A plain number is
+// accepted as well and taken as the power.
+type dischargeCommand struct {
+	Power    int    `json:"power"`
+	Duration string `json:"duration"`
+}
+
+// handleCommand executes a command given by the topic below <command_topic>.
+func (c *Client) handleCommand(name, command string, payload []byte) {
+	c.mutex.Lock()
+	b, ok := c.batteries[name]
+	c.mutex.Unlock()
+	if !ok {
+		return
+	}
+	controller := b.controller
+
+	value := strings.TrimSpace(string(payload))
+	log := c.log.With(
+		slog.String("battery", name),
+		slog.String("command", command),
+		slog.String("payload", value),
+	)
+
+	var err error
+	switch command {
+	case "discharge":
+		var power int
+		var duration time.Duration
+		power, duration, err = parseDischarge(value)
+		if err == nil {
+			err = controller.ForceDischarge(commandSource, power, duration)
+		}
+	case "discharge_switch":
+		var on bool
+		on, err = parseSwitch(value)
+		if err == nil && on {
+			err = controller.ForceDischarge(commandSource, 0, 0)
+		} else if err == nil {
+			err = controller.CancelDischarge(commandSource)
+		}
+	case "cancel", "stop":
+		err = controller.CancelDischarge(commandSource)
+	case "pause":
+		var paused bool
+		paused, err = parseSwitch(value)
+		if err == nil {
+			err = controller.Pause(commandSource, paused)
+		}
+	case "soc_limit":
+		var limit float64
+		limit, err = strconv.ParseFloat(value, 64)
+		if err == nil && (limit < 0 || limit > 100) {
+			err = fmt.Errorf("SoC limit out of range: %v", limit)
+		}
+		if err == nil {
+			err = controller.SetSocLimit(commandSource, limit)
+		}
+	case "log_level":
+		if c.setLevel == nil {
+			err = errors.New("log level changes not enabled")
+			break
+		}
+		err = c.setLevel(value)
+	default:
+		id, isSchedule := strings.CutPrefix(command, "schedule/")
+		if !isSchedule {
+			err = errors.New("unknown command")
+			break
+		}
+		label, known := b.schedule(id)
+		if !known {
+			err = fmt.Errorf("unknown schedule %q", id)
+			break
+		}
+		var enabled bool
+		enabled, err = parseSwitch(value)
+		if err == nil {
+			err = controller.EnableSchedule(commandSource, label, enabled)
+		}
+	}
+
+	if err != nil {
+		log.With(sl.Err(err)).Warn("rejected command")
+		return
+	}
+	log.Info("received command")
+}
+
+func parseDischarge(value string) (int, time.Duration, error) {
+	if power, err := strconv.Atoi(value); err == nil {
+		return power, 0, validatePower(power)
+	}
+	var cmd dischargeCommand
+	if err := json.Unmarshal([]byte(value), &cmd); err != nil {
+		return 0, 0, fmt.Errorf("invalid discharge payload: %w", err)
+	}
+	var duration time.Duration
+	if cmd.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(cmd.Duration)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid duration: %w", err)
+		}
+	}
+	return cmd.Power, duration, validatePower(cmd.Power)
+}
+
+func validatePower(power int) error {
+	if power <= 0 {
+		return fmt.Errorf("power must be positive, got %d", power)
+	}
+	return nil
+}
+
+// parseSwitch accepts the payloads used by Home Assistant and common MQTT tools.
+func parseSwitch(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid switch payload %q", value)
+}
+
+func (c *Client) publishJSON(topic string, v any) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		c.log.With(sl.Err(err), slog.String("topic", topic)).Error("marshalling payload")
+		return
+	}
+	c.publish(topic, payload, c.conf.Retain)
+}
+
+// publish queues a message without blocking, so that an unreachable broker does not
+// stall the discharge workers. Messages are dropped while the queue is full.
+func (c *Client) publish(topic string, payload any, retained bool) {
+	select {
+	case c.queue <- message{topic: topic, payload: payload, retained: retained}:
+	default:
+		c.log.With(slog.String("topic", topic)).Debug("publish queue full, dropping message")
+	}
+}
+
+// runPublisher sends the queued messages until the client is closed.
+func (c *Client) runPublisher() {
+	for {
+		select {
+		case m := <-c.queue:
+			c.send(m)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) send(m message) {
+	token := c.client.Publish(m.topic, byte(c.conf.Qos), m.retained, m.payload)
+	if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		c.log.With(sl.Err(token.Error()), slog.String("topic", m.topic)).Debug("publishing")
+	}
+}
@@ -0,0 +1,196 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"gok-pi/internal/config"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeController records the commands it receives.
+type fakeController struct {
+	calls []string
+}
+
+func (f *fakeController) ForceDischarge(source string, power int, duration time.Duration) error {
+	f.calls = append(f.calls, fmt.Sprintf("%s force %d %s", source, power, duration))
+	return nil
+}
+
+func (f *fakeController) CancelDischarge(source string) error {
+	f.calls = append(f.calls, source+" cancel")
+	return nil
+}
+
+func (f *fakeController) Pause(source string, paused bool) error {
+	f.calls = append(f.calls, fmt.Sprintf("%s pause %v", source, paused))
+	return nil
+}
+
+func (f *fakeController) SetSocLimit(source string, limit float64) error {
+	f.calls = append(f.calls, fmt.Sprintf("%s soc_limit %v", source, limit))
+	return nil
+}
+
+func (f *fakeController) EnableSchedule(source, label string, enabled bool) error {
+	f.calls = append(f.calls, fmt.Sprintf("%s schedule %s %v", source, label, enabled))
+	return nil
+}
+
+func TestHandleCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		payload string
+		want    []string
+	}{
+		{"discharge", "800", []string{"mqtt force 800 0s"}},
+		{"discharge", `{"power": 500, "duration": "30m"}`, []string{"mqtt force 500 30m0s"}},
+		{"discharge", "-5", nil},
+		{"discharge", `{"power": 500, "duration": "soon"}`, nil},
+		{"discharge_switch", "ON", []string{"mqtt force 0 0s"}},
+		{"discharge_switch", "off", []string{"mqtt cancel"}},
+		{"cancel", "", []string{"mqtt cancel"}},
+		{"stop", "", []string{"mqtt cancel"}},
+		{"pause", "true", []string{"mqtt pause true"}},
+		{"pause", "maybe", nil},
+		{"soc_limit", "45.5", []string{"mqtt soc_limit 45.5"}},
+		{"soc_limit", "101", nil},
+		{"schedule/evening", "ON", []string{"mqtt schedule evening true"}},
+		{"schedule/20_00-22_00", "OFF", []string{"mqtt schedule 20:00-22:00 false"}},
+		{"schedule/20:00-22:00", "ON", []string{"mqtt schedule 20:00-22:00 true"}},
+		{"schedule/morning", "ON", nil},
+		{"reboot", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command+" "+tt.payload, func(t *testing.T) {
+			controller := &fakeController{}
+			c := New(config.Mqtt{Broker: "tcp://127.0.0.1:1"}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			c.AddBattery("b1", controller, []string{"evening", "20:00-22:00"})
+			c.handleCommand("b1", tt.command, []byte(tt.payload))
+			if !reflect.DeepEqual(controller.calls, tt.want) {
+				t.Errorf("calls = %q, want %q", controller.calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleLogLevel(t *testing.T) {
+	c := New(config.Mqtt{Broker: "tcp://127.0.0.1:1"}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c.AddBattery("b1", &fakeController{}, nil)
+	var level string
+	c.SetLogLevel(func(l string) error {
+		level = l
+		return nil
+	})
+	c.handleCommand("b1", "log_level", []byte(" debug\n"))
+	if level != "debug" {
+		t.Errorf("level = %q, want debug", level)
+	}
+}
+
+func TestParseDischarge(t *testing.T) {
+	tests := []struct {
+		payload  string
+		power    int
+		duration time.Duration
+		wantErr  bool
+	}{
+		{"800", 800, 0, false},
+		{`{"power": 600}`, 600, 0, false},
+		{`{"power": 600, "duration": "1h30m"}`, 600, 90 * time.Minute, false},
+		{"0", 0, 0, true},
+		{`{"duration": "1h"}`, 0, time.Hour, true},
+		{`{"power": 600, "duration": "1x"}`, 0, 0, true},
+		{"fast", 0, 0, true},
+	}
+	for _, tt := range tests {
+		power, duration, err := parseDischarge(tt.payload)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDischarge(%q) error = %v, want error %v", tt.payload, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (power != tt.power || duration != tt.duration) {
+			t.Errorf("parseDischarge(%q) = %d, %s, want %d, %s", tt.payload, power, duration, tt.power, tt.duration)
+		}
+	}
+}
+
+func TestParseSwitch(t *testing.T) {
+	for _, payload := range []string{"ON", "on", "true", "1"} {
+		if on, err := parseSwitch(payload); err != nil || !on {
+			t.Errorf("parseSwitch(%q) = %v, %v, want true", payload, on, err)
+		}
+	}
+	for _, payload := range []string{"OFF", "off", "false", "0"} {
+		if on, err := parseSwitch(payload); err != nil || on {
+			t.Errorf("parseSwitch(%q) = %v, %v, want false", payload, on, err)
+		}
+	}
+	for _, payload := range []string{"", "yes", "2"} {
+		if _, err := parseSwitch(payload); err == nil {
+			t.Errorf("parseSwitch(%q) succeeded, want error", payload)
+		}
+	}
+}
+
+func TestPublishDoesNotBlock(t *testing.T) {
+	c := New(config.Mqtt{Broker: "tcp://127.0.0.1:1"}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer c.Close()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*publishQueueSize; i++ {
+			c.PublishStatus("b1", nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishing blocked without a broker")
+	}
+}
+
+// pendingToken is a token that never completes, like one of a broker that does not answer.
+type pendingToken struct {
+	paho.Token
+}
+
+func (pendingToken) WaitTimeout(time.Duration) bool {
+	return false
+}
+
+func (pendingToken) Error() error {
+	return nil
+}
+
+// unansweredClient is a client whose subscriptions are never acknowledged.
+type unansweredClient struct {
+	paho.Client
+}
+
+func (unansweredClient) Subscribe(string, byte, paho.MessageHandler) paho.Token {
+	return pendingToken{}
+}
+
+func TestSubscribeTimeout(t *testing.T) {
+	var logs bytes.Buffer
+	c := New(config.Mqtt{Broker: "tcp://127.0.0.1:1", CommandTopic: "gok-pi/{battery}/set"},
+		slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer c.Close()
+	client := c.client
+	c.client = unansweredClient{}
+	defer func() {
+		c.client = client
+	}()
+
+	c.subscribe("b1")
+	if out := logs.String(); !strings.Contains(out, "level=WARN") || strings.Contains(out, "subscribed to commands") {
+		t.Errorf("logs = %q, want a warning and no subscription", out)
+	}
+}
@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"gok-pi/internal/config"
+	"gok-pi/internal/lib/sl"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	queueSize   = 32
+	sendTimeout = 30 * time.Second
+)
+
+// Kind classifies events. Events of one kind and battery are rate limited by the cooldown.
+type Kind string
+
+const (
+	KindApiFailures  Kind = "api_failures"
+	KindRecovered    Kind = "recovered"
+	KindStuckManual  Kind = "stuck_manual"
+	KindWatchdog     Kind = "watchdog"
+	KindSocFloor     Kind = "soc_floor"
+	KindDailySummary Kind = "daily_summary"
+)
+
+// Event is a notification. Battery is empty for events not related to a single battery.
+type Event struct {
+	Kind    Kind      `json:"kind"`
+	Battery string    `json:"battery,omitempty"`
+	Time    time.Time `json:"time"`
+	Title   string    `json:"title"`
+	Text    string    `json:"text"`
+}
+
+// Channel delivers events to one destination.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, e Event) error
+}
+
+// Notifier queues events and delivers them to all channels in the background,
+// so that a slow mail server never delays the discharge workers.
+type Notifier struct {
+	channels []Channel
+	cooldown time.Duration
+	mutex    sync.Mutex
+	sent     map[string]time.Time
+	closed   bool
+	queue    chan Event
+	done     chan struct{}
+	log      *slog.Logger
+}
+
+// New creates a notifier with every channel configured in conf.
+func New(conf config.Notify, log *slog.Logger) *Notifier {
+	var channels []Channel
+	if conf.Webhook.Url != "" {
+		channels = append(channels, NewWebhook(conf.Webhook.Url))
+	}
+	if conf.Smtp.Host != "" {
+		channels = append(channels, NewSmtp(conf.Smtp))
+	}
+	if conf.Telegram.Token != "" {
+		channels = append(channels, NewTelegram(conf.Telegram.ApiUrl, conf.Telegram.Token, conf.Telegram.ChatId))
+	}
+	return NewWithChannels(channels, conf.Cooldown, log)
+}
+
+// NewWithChannels creates a notifier delivering to the given channels.
+func NewWithChannels(channels []Channel, cooldown time.Duration, log *slog.Logger) *Notifier {
+	n := &Notifier{
+		channels: channels,
+		cooldown: cooldown,
+		sent:     make(map[string]time.Time),
+		queue:    make(chan Event, queueSize),
+		done:     make(chan struct{}),
+		log:      log.With(sl.Module("notify")),
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues the event unless an event of the same kind and battery was queued within
+// the cooldown. Events are dropped if the queue is full.
+func (n *Notifier) Notify(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	log := n.log.With(slog.String("kind", string(e.Kind)), slog.String("battery", e.Battery))
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		return
+	}
+	key := string(e.Kind) + "/" + e.Battery
+	if last, ok := n.sent[key]; ok && e.Time.Sub(last) < n.cooldown {
+		log.Debug("notification suppressed by cooldown")
+		return
+	}
+	select {
+	case n.queue <- e:
+		n.sent[key] = e.Time
+	default:
+		log.Warn("notification queue full, event dropped")
+	}
+}
+
+// Close delivers the queued events and stops the notifier.
+func (n *Notifier) Close() {
+	n.mutex.Lock()
+	if n.closed {
+		n.mutex.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.queue)
+	n.mutex.Unlock()
+	<-n.done
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for e := range n.queue {
+		for _, ch := range n.channels {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			err := ch.Send(ctx, e)
+			cancel()
+			log := n.log.With(
+				slog.String("channel", ch.Name()),
+				slog.String("kind", string(e.Kind)),
+				slog.String("battery", e.Battery),
+			)
+			if err != nil {
+				log.With(sl.Err(err)).Error("sending notification")
+				continue
+			}
+			log.Debug("notification sent")
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"gok-pi/internal/config"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Smtp mails events to a list of recipients. Authentication is used if a username is set.
+type Smtp struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func NewSmtp(conf config.Smtp) *Smtp {
+	return &Smtp{
+		addr:     net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port)),
+		host:     conf.Host,
+		username: conf.Username,
+		password: conf.Password,
+		from:     conf.From,
+		to:       conf.To,
+	}
+}
+
+func (s *Smtp) Name() string {
+	return "smtp"
+}
+
+// Send delivers the mail like smtp.SendMail, upgrading to TLS if the server supports it.
+// The connection is bound to the deadline of ctx, so that a hung server cannot block the
+// notifier.
+func (s *Smtp) Send(ctx context.Context, e Event) error {
+	if err := s.send(ctx, e); err != nil {
+		return fmt.Errorf("sending mail: %w", err)
+	}
+	return nil
+}
+
+func (s *Smtp) send(ctx context.Context, e Event) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(s.message(e)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *Smtp) message(e Event) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&b, "Subject: [gok-pi] %s\r\n", e.Title)
+	fmt.Fprintf(&b, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(e.Text, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"context"
+	"gok-pi/internal/config"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSmtpSendTimesOut(t *testing.T) {
+	// the server accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	s := NewSmtp(config.Smtp{Host: host, Port: p, From: "gok@example.com", To: []string{"admin@example.com"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Send(ctx, Event{Title: "test", Time: time.Now()})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("send to a hung server succeeded, want error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("send did not return after the deadline")
+	}
+}
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"gok-pi/battery/energy"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunDailySummary sends the energy totals of the previous day at the given offset from
+// midnight, every day until ctx is done, so that the summary covers a whole day
+// regardless of the time it is sent.
+func (n *Notifier) RunDailySummary(ctx context.Context, store *energy.Store, at time.Duration, batteries []string) {
+	for {
+		next := nextDaily(time.Now(), at)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		day := next.AddDate(0, 0, -1)
+		n.Notify(Event{
+			Kind:  KindDailySummary,
+			Time:  next,
+			Title: "Daily summary " + day.Format("2006-01-02"),
+			Text:  Summary(store.Day(day), batteries),
+		})
+	}
+}
+
+// Summary formats the energy totals of one day, one line per battery with
+// the discharged energy broken down by schedule.
+func Summary(day map[string]map[string]energy.Totals, batteries []string) string {
+	var lines []string
+	for _, battery := range batteries {
+		var discharged, charged float64
+		var parts []string
+		schedules := day[battery]
+		labels := make([]string, 0, len(schedules))
+		for label := range schedules {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			t := schedules[label]
+			discharged += t.DischargedWh
+			charged += t.ChargedWh
+			if t.DischargedWh > 0 {
+				parts = append(parts, fmt.Sprintf("%s %.2f kWh", label, t.DischargedWh/1000))
+			}
+		}
+		line := fmt.Sprintf("%s: discharged %.2f kWh, charged %.2f kWh", battery, discharged/1000, charged/1000)
+		if len(parts) > 0 {
+			line += " (" + strings.Join(parts, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// nextDaily returns the first time after now with the given wall clock offset from
+// midnight, built from its clock fields so that it is kept on DST days.
+func nextDaily(now time.Time, at time.Duration) time.Time {
+	y, m, d := now.Date()
+	hour, minute := int(at/time.Hour), int(at%time.Hour/time.Minute)
+	next := time.Date(y, m, d, hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = time.Date(y, m, d+1, hour, minute, 0, 0, now.Location())
+	}
+	return next
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"gok-pi/battery/energy"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestNextDaily(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		now, want string
+	}{
+		{"2024-08-14 06:00", "2024-08-14 07:00"},
+		{"2024-08-14 07:00", "2024-08-15 07:00"},
+		{"2024-03-31 01:00", "2024-03-31 07:00"},
+		{"2024-10-27 01:00", "2024-10-27 07:00"},
+	}
+	for _, tt := range tests {
+		now, _ := time.ParseInLocation("2006-01-02 15:04", tt.now, berlin)
+		want, _ := time.ParseInLocation("2006-01-02 15:04", tt.want, berlin)
+		if got := nextDaily(now, 7*time.Hour); !got.Equal(want) {
+			t.Errorf("nextDaily(%s) = %s, want %s", tt.now, got, want)
+		}
+	}
+}
+
+// recordingChannel records the events sent to it.
+type recordingChannel struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (c *recordingChannel) Name() string {
+	return "recording"
+}
+
+func (c *recordingChannel) Send(_ context.Context, e Event) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.events = append(c.events, e)
+	return nil
+}
+
+func TestRunDailySummaryStopsOnShutdown(t *testing.T) {
+	store, err := energy.NewStore(filepath.Join(t.TempDir(), "energy.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := &recordingChannel{}
+	n := NewWithChannels([]Channel{ch}, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.RunDailySummary(ctx, store, 7*time.Hour, []string{"b1"})
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunDailySummary still running after shutdown")
+	}
+	n.Close()
+	if len(ch.events) > 0 {
+		t.Errorf("events = %+v, want none", ch.events)
+	}
+}
@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Telegram sends events through a Telegram compatible bot API:
+// POST <api_url>/bot<token>/sendMessage with {"chat_id": ..., "text": ...}.
+type Telegram struct {
+	apiUrl string
+	token  string
+	chatId string
+}
+
+func NewTelegram(apiUrl, token, chatId string) *Telegram {
+	return &Telegram{
+		apiUrl: strings.TrimSuffix(apiUrl, "/"),
+		token:  token,
+		chatId: chatId,
+	}
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+func (t *Telegram) Send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(map[string]string{
+		"chat_id": t.chatId,
+		"text":    e.Title + "\n" + e.Text,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+	// the token is part of the path, keep it out of returned errors
+	err = postJSON(ctx, fmt.Sprintf("%s/bot%s/sendMessage", t.apiUrl, t.token), body)
+	if err != nil {
+		return fmt.Errorf("sending message: %s", strings.ReplaceAll(err.Error(), t.token, "***"))
+	}
+	return nil
+}
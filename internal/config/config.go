@@ -6,43 +6,252 @@ import (
 	"gok-pi/battery/entity"
 	"log"
 	"sync"
+	"time"
 )
 
 type Config struct {
 	Env       string            `yaml:"env" env-default:"local" env-required:"true"`
+	Log       Log               `yaml:"log"`
 	Schedules []entity.Schedule `yaml:"schedules"`
 	Metrics   MetricsServer     `yaml:"metrics"`
+	Energy    Energy            `yaml:"energy"`
+	Recorder  Recorder          `yaml:"recorder"`
+	Mqtt      Mqtt              `yaml:"mqtt"`
+	Notify    Notify            `yaml:"notify"`
+	Watchdog  Watchdog          `yaml:"watchdog"`
+	Audit     Audit             `yaml:"audit"`
 	Batteries []BatteryConfig   `yaml:"batteries"`
 }
 
+// Log configures the service log. Empty Level, Format and Output follow Env: debug text
+// on stdout for local, debug JSON to the file for dev and info JSON to the file for prod.
+// Path defaults to gok-pi.log in the directory given by the -log flag. The file is rotated
+// at MaxSizeMb or after MaxAge, keeping MaxFiles old files, gzipped if Compress is set.
+type Log struct {
+	Level     string        `yaml:"level"`
+	Format    string        `yaml:"format"`
+	Output    string        `yaml:"output"`
+	Path      string        `yaml:"path"`
+	MaxSizeMb int           `yaml:"max_size_mb" env-default:"50"`
+	MaxAge    time.Duration `yaml:"max_age" env-default:"0s"`
+	MaxFiles  int           `yaml:"max_files" env-default:"5"`
+	Compress  bool          `yaml:"compress" env-default:"true"`
+}
+
+// BatteryConfig describes a battery controller. The API token is given by exactly one of
+// Token, TokenFile, TokenEnv or TokenCredential, the latter being a systemd credential name
+// looked up in $CREDENTIALS_DIRECTORY, so that the token can be kept out of the config file.
 type BatteryConfig struct {
-	Name          string `yaml:"name" env-default:"battery1"`
-	Url           string `yaml:"url" env-default:"https://example.battery/api"`
-	Token         string `yaml:"token" env-default:"auth-token"`
-	Enabled       bool   `yaml:"enabled" env-default:"true"`
-	Discharge     bool   `yaml:"discharge" env-default:"false"`
-	CapacityLimit int    `yaml:"capacity_limit" env-default:"20000"`
-	PowerLimit    int    `yaml:"power_limit" env-default:"1000"`
-	SocLimit      int    `yaml:"soc_limit" env-default:"50"`
+	Name            string          `yaml:"name" env-default:"battery1"`
+	Url             string          `yaml:"url" env-default:"https://example.battery/api"`
+	Token           string          `yaml:"token"`
+	TokenFile       string          `yaml:"token_file"`
+	TokenEnv        string          `yaml:"token_env"`
+	TokenCredential string          `yaml:"token_credential"`
+	Enabled         bool            `yaml:"enabled" env-default:"true"`
+	Discharge       bool            `yaml:"discharge" env-default:"false"`
+	CapacityLimit   int             `yaml:"capacity_limit" env-default:"20000"`
+	PowerLimit      int             `yaml:"power_limit" env-default:"1000"`
+	SocLimit        int             `yaml:"soc_limit" env-default:"50"`
+	Strategy        string          `yaml:"strategy" env-default:"fixed_window"`
+	Forecast        Forecast        `yaml:"forecast"`
+	PollInterval    time.Duration   `yaml:"poll_interval" env-default:"10s"`
+	AdaptivePolling AdaptivePolling `yaml:"adaptive_polling"`
 }
 
-type MetricsServer struct {
+// AdaptivePolling polls with FastInterval while discharging or within BoundaryMargin
+// of a schedule start or stop, and with SlowInterval when idle.
+type AdaptivePolling struct {
+	Enabled        bool          `yaml:"enabled" env-default:"false"`
+	FastInterval   time.Duration `yaml:"fast_interval" env-default:"5s"`
+	SlowInterval   time.Duration `yaml:"slow_interval" env-default:"60s"`
+	BoundaryMargin time.Duration `yaml:"boundary_margin" env-default:"5m"`
+}
+
+// Forecast raises the schedule SoC limit when tomorrow's PV production is expected to be low.
+// Source is either a local JSON file (Path) or a forecast.solar compatible endpoint (Url),
+// read in the background every Refresh.
+type Forecast struct {
+	Enabled         bool          `yaml:"enabled" env-default:"false"`
+	Url             string        `yaml:"url"`
+	Path            string        `yaml:"path"`
+	Refresh         time.Duration `yaml:"refresh" env-default:"1h"`
+	MinProductionWh float64       `yaml:"min_production_wh" env-default:"10000"`
+	SocLimit        int           `yaml:"soc_limit" env-default:"90"`
+}
+
+// Energy persists daily discharged and charged energy totals per battery and schedule.
+type Energy struct {
+	Enabled       bool   `yaml:"enabled" env-default:"false"`
+	Path          string `yaml:"path" env-default:"/var/lib/gok-pi/energy.json"`
+	RetentionDays int    `yaml:"retention_days" env-default:"400"`
+}
+
+// Recorder appends every status poll to daily CSV files per battery.
+type Recorder struct {
+	Enabled       bool   `yaml:"enabled" env-default:"false"`
+	Path          string `yaml:"path" env-default:"/var/lib/gok-pi/history"`
+	RetentionDays int    `yaml:"retention_days" env-default:"30"`
+}
+
+// Mqtt publishes battery status and worker state and receives commands. Topics may contain
+// {battery}, replaced by the battery name; commands are received on <command_topic>/<command>.
+type Mqtt struct {
+	Enabled           bool      `yaml:"enabled" env-default:"false"`
+	Broker            string    `yaml:"broker" env-default:"tcp://localhost:1883"`
+	ClientId          string    `yaml:"client_id" env-default:"gok-pi"`
+	Username          string    `yaml:"username"`
+	Password          string    `yaml:"password" env:"GOK_MQTT_PASSWORD"`
+	Qos               int       `yaml:"qos" env-default:"1"`
+	Retain            bool      `yaml:"retain" env-default:"true"`
+	StatusTopic       string    `yaml:"status_topic" env-default:"gok-pi/{battery}/status"`
+	StateTopic        string    `yaml:"state_topic" env-default:"gok-pi/{battery}/state"`
+	CommandTopic      string    `yaml:"command_topic" env-default:"gok-pi/{battery}/set"`
+	AvailabilityTopic string    `yaml:"availability_topic" env-default:"gok-pi/availability"`
+	Discovery         Discovery `yaml:"discovery"`
+}
+
+// Discovery announces the batteries to Home Assistant via MQTT discovery.
+type Discovery struct {
 	Enabled bool   `yaml:"enabled" env-default:"false"`
-	Bind    string `yaml:"bind" env-default:"0.0.0.0"`
-	Port    string `yaml:"port" env-default:"5001"`
+	Prefix  string `yaml:"prefix" env-default:"homeassistant"`
+}
+
+// Notify sends alerts on repeated API failures, a battery stuck in manual mode and the SoC
+// limit being reached, and a summary of the previous day's energy at DailySummary (HH:MM,
+// empty disables it). Every configured channel receives all events; the URLs may point to local stubs.
+type Notify struct {
+	Enabled          bool          `yaml:"enabled" env-default:"false"`
+	FailureThreshold int           `yaml:"failure_threshold" env-default:"3"`
+	StuckManualAfter time.Duration `yaml:"stuck_manual_after" env-default:"15m"`
+	Cooldown         time.Duration `yaml:"cooldown" env-default:"1h"`
+	DailySummary     string        `yaml:"daily_summary"`
+	Webhook          Webhook       `yaml:"webhook"`
+	Smtp             Smtp          `yaml:"smtp"`
+	Telegram         Telegram      `yaml:"telegram"`
+}
+
+// Webhook posts every event as JSON to Url.
+type Webhook struct {
+	Url string `yaml:"url"`
+}
+
+type Smtp struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port" env-default:"587"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password" env:"GOK_SMTP_PASSWORD"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// Telegram sends messages through a Telegram compatible bot API.
+type Telegram struct {
+	ApiUrl string `yaml:"api_url" env-default:"https://api.telegram.org"`
+	Token  string `yaml:"token" env:"GOK_TELEGRAM_TOKEN"`
+	ChatId string `yaml:"chat_id"`
+}
+
+// Watchdog switches a battery back to automatic mode when it stays in manual mode for
+// GracePeriod outside any schedule without being discharged by gok-pi.
+type Watchdog struct {
+	Enabled     bool          `yaml:"enabled" env-default:"true"`
+	GracePeriod time.Duration `yaml:"grace_period" env-default:"5m"`
+}
+
+// Audit appends every setpoint and operating mode change as a JSON line to Path, rotating
+// it at MaxSizeMb and keeping MaxFiles old files.
+type Audit struct {
+	Enabled   bool   `yaml:"enabled" env-default:"false"`
+	Path      string `yaml:"path" env-default:"/var/lib/gok-pi/audit.jsonl"`
+	MaxSizeMb int    `yaml:"max_size_mb" env-default:"10"`
+	MaxFiles  int    `yaml:"max_files" env-default:"5"`
+}
+
+// MetricsServer serves /metrics, /healthz and /readyz. A battery is ready if it was polled
+// successfully within ReadyIntervals of its polling interval. LegacyNames additionally
+// exports the status gauges under their names before the renaming, e.g. battery_RSoC.
+type MetricsServer struct {
+	Enabled        bool   `yaml:"enabled" env-default:"false"`
+	Bind           string `yaml:"bind" env-default:"0.0.0.0"`
+	Port           string `yaml:"port" env-default:"5001"`
+	ReadyIntervals int    `yaml:"ready_intervals" env-default:"6"`
+	LegacyNames    bool   `yaml:"legacy_names" env-default:"false"`
 }
 
 var instance *Config
 var once sync.Once
 
+// Read parses the configuration file and environment without validating the values.
+func Read(path string) (*Config, error) {
+	conf := &Config{}
+	if err := cleanenv.ReadConfig(path, conf); err != nil {
+		desc, _ := cleanenv.GetDescription(conf, nil)
+		return nil, fmt.Errorf("%s; %s", err, desc)
+	}
+	conf.applyDefaults()
+	return conf, nil
+}
+
+// applyDefaults fills unset battery options. cleanenv does not apply env-default
+// tags to slice elements, so the defaults are repeated here.
+func (c *Config) applyDefaults() {
+	for i := range c.Batteries {
+		b := &c.Batteries[i]
+		if b.Strategy == "" {
+			b.Strategy = "fixed_window"
+		}
+		if b.PowerLimit == 0 {
+			b.PowerLimit = 1000
+		}
+		if b.SocLimit == 0 {
+			b.SocLimit = 50
+		}
+		if b.PollInterval == 0 {
+			b.PollInterval = 10 * time.Second
+		}
+		if b.AdaptivePolling.FastInterval == 0 {
+			b.AdaptivePolling.FastInterval = 5 * time.Second
+		}
+		if b.AdaptivePolling.SlowInterval == 0 {
+			b.AdaptivePolling.SlowInterval = time.Minute
+		}
+		if b.AdaptivePolling.BoundaryMargin == 0 {
+			b.AdaptivePolling.BoundaryMargin = 5 * time.Minute
+		}
+		if b.Forecast.Refresh == 0 {
+			b.Forecast.Refresh = time.Hour
+		}
+		if b.Forecast.MinProductionWh == 0 {
+			b.Forecast.MinProductionWh = 10000
+		}
+		if b.Forecast.SocLimit == 0 {
+			b.Forecast.SocLimit = 90
+		}
+	}
+}
+
+// Load reads the configuration file and validates it.
+// Validation problems are returned as a *ValidationError.
+func Load(path string) (*Config, error) {
+	conf, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+	if err = conf.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
 func MustLoad(path string) *Config {
-	var err error
 	once.Do(func() {
-		instance = &Config{}
-		if err = cleanenv.ReadConfig(path, instance); err != nil {
-			desc, _ := cleanenv.GetDescription(instance, nil)
-			err = fmt.Errorf("%s; %s", err, desc)
-			instance = nil
+		var err error
+		instance, err = Load(path)
+		if err != nil {
 			log.Fatal(err)
 		}
 	})
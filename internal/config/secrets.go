@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// credentialsDirEnv is set by systemd for units using LoadCredential= or SetCredential=.
+const credentialsDirEnv = "CREDENTIALS_DIRECTORY"
+
+// tokenSources returns the names of the token options set for the battery.
+func (b *BatteryConfig) tokenSources() []string {
+	var sources []string
+	if b.Token != "" {
+		sources = append(sources, "token")
+	}
+	if b.TokenFile != "" {
+		sources = append(sources, "token_file")
+	}
+	if b.TokenEnv != "" {
+		sources = append(sources, "token_env")
+	}
+	if b.TokenCredential != "" {
+		sources = append(sources, "token_credential")
+	}
+	return sources
+}
+
+// ResolveSecrets loads the tokens of enabled batteries given by token_file, token_env or
+// token_credential into BatteryConfig.Token. Disabled batteries are skipped, so that their
+// missing secrets do not prevent the service from starting; see ResolveToken.
+// Problems are returned as a *ValidationError.
+func (c *Config) ResolveSecrets() error {
+	v := &validator{}
+	for i := range c.Batteries {
+		b := &c.Batteries[i]
+		if !b.Enabled {
+			continue
+		}
+		path := fmt.Sprintf("batteries[%d]", i)
+		if field, err := b.resolveToken(); err != nil {
+			v.add(path+"."+field, "%s", err)
+		}
+	}
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// ResolveToken loads the token of a single battery, e.g. of a disabled battery selected
+// by a CLI command.
+func (b *BatteryConfig) ResolveToken() error {
+	if field, err := b.resolveToken(); err != nil {
+		return fmt.Errorf("battery %s: %s: %w", b.Name, field, err)
+	}
+	return nil
+}
+
+// resolveToken loads the token into Token and returns the name of the option used.
+func (b *BatteryConfig) resolveToken() (string, error) {
+	token, field, err := b.readToken()
+	if err != nil {
+		return field, err
+	}
+	if field != "token" && token == "" {
+		return field, errors.New("token is empty")
+	}
+	b.Token = token
+	return field, nil
+}
+
+// readToken returns the token from the configured source and the name of the option used.
+func (b *BatteryConfig) readToken() (string, string, error) {
+	switch {
+	case b.TokenFile != "":
+		token, err := readSecretFile(b.TokenFile)
+		return token, "token_file", err
+	case b.TokenEnv != "":
+		token, ok := os.LookupEnv(b.TokenEnv)
+		if !ok {
+			return "", "token_env", fmt.Errorf("environment variable %s is not set", b.TokenEnv)
+		}
+		return strings.TrimSpace(token), "token_env", nil
+	case b.TokenCredential != "":
+		dir := os.Getenv(credentialsDirEnv)
+		if dir == "" {
+			return "", "token_credential", fmt.Errorf("%s is not set, is the service started by systemd with LoadCredential?", credentialsDirEnv)
+		}
+		token, err := readSecretFile(filepath.Join(dir, b.TokenCredential))
+		return token, "token_credential", err
+	}
+	return b.Token, "token", nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,321 @@
+package config
+
+import (
+	"fmt"
+	"gok-pi/battery/strategy"
+	"gok-pi/internal/lib/logger"
+	"gok-pi/internal/lib/timer"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem is a configuration issue located by the path of the offending field,
+// e.g. "schedules[1].start_time".
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// ValidationError lists all problems found in a configuration.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, p.String())
+	}
+	return fmt.Sprintf("invalid config: %s", strings.Join(lines, "; "))
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(path, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the configuration for values that would only fail at runtime.
+// It returns a *ValidationError with every problem found, or nil.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	switch c.Env {
+	case "local", "dev", "prod":
+	default:
+		v.add("env", "unknown environment %q, expected local, dev or prod", c.Env)
+	}
+
+	if c.Log.Level != "" {
+		if _, err := logger.ParseLevel(c.Log.Level); err != nil {
+			v.add("log.level", "unknown level %q, expected debug, info, warn or error", c.Log.Level)
+		}
+	}
+	switch c.Log.Format {
+	case "", logger.FormatText, logger.FormatJSON:
+	default:
+		v.add("log.format", "unknown format %q, expected text or json", c.Log.Format)
+	}
+	switch c.Log.Output {
+	case "", logger.OutputStdout, logger.OutputFile, logger.OutputJournald:
+	default:
+		v.add("log.output", "unknown output %q, expected stdout, file or journald", c.Log.Output)
+	}
+	if c.Log.MaxSizeMb < 0 {
+		v.add("log.max_size_mb", "must not be negative")
+	}
+	if c.Log.MaxAge < 0 {
+		v.add("log.max_age", "must not be negative")
+	}
+	if c.Log.MaxFiles < 0 {
+		v.add("log.max_files", "must not be negative")
+	}
+
+	if c.Metrics.Enabled {
+		port, err := strconv.Atoi(c.Metrics.Port)
+		if err != nil || port < 1 || port > 65535 {
+			v.add("metrics.port", "invalid port %q", c.Metrics.Port)
+		}
+		if c.Metrics.ReadyIntervals < 1 {
+			v.add("metrics.ready_intervals", "must be at least 1")
+		}
+	}
+
+	if c.Energy.Enabled {
+		if c.Energy.Path == "" {
+			v.add("energy.path", "must not be empty")
+		}
+		if c.Energy.RetentionDays < 0 {
+			v.add("energy.retention_days", "must not be negative")
+		}
+	}
+
+	if c.Recorder.Enabled {
+		if c.Recorder.Path == "" {
+			v.add("recorder.path", "must not be empty")
+		}
+		if c.Recorder.RetentionDays < 0 {
+			v.add("recorder.retention_days", "must not be negative")
+		}
+	}
+
+	if c.Mqtt.Enabled {
+		if u, err := url.Parse(c.Mqtt.Broker); err != nil || u.Scheme == "" || u.Host == "" {
+			v.add("mqtt.broker", "invalid broker url %q, expected e.g. tcp://localhost:1883", c.Mqtt.Broker)
+		}
+		if c.Mqtt.Qos < 0 || c.Mqtt.Qos > 2 {
+			v.add("mqtt.qos", "must be 0, 1 or 2, got %d", c.Mqtt.Qos)
+		}
+		topics := []struct{ field, topic string }{
+			{"status_topic", c.Mqtt.StatusTopic},
+			{"state_topic", c.Mqtt.StateTopic},
+			{"command_topic", c.Mqtt.CommandTopic},
+		}
+		for _, t := range topics {
+			if !strings.Contains(t.topic, "{battery}") {
+				v.add("mqtt."+t.field, "must contain {battery}, got %q", t.topic)
+			}
+		}
+	}
+
+	if c.Notify.Enabled {
+		n := c.Notify
+		if n.Webhook.Url == "" && n.Smtp.Host == "" && n.Telegram.Token == "" {
+			v.add("notify", "at least one of webhook.url, smtp.host or telegram.token is required")
+		}
+		if n.FailureThreshold < 1 {
+			v.add("notify.failure_threshold", "must be at least 1")
+		}
+		if n.StuckManualAfter <= 0 {
+			v.add("notify.stuck_manual_after", "must be positive")
+		}
+		if n.Cooldown < 0 {
+			v.add("notify.cooldown", "must not be negative")
+		}
+		if n.DailySummary != "" {
+			if _, err := timer.ParseClock(n.DailySummary); err != nil {
+				v.add("notify.daily_summary", "invalid time %q, expected HH:MM", n.DailySummary)
+			}
+		}
+		if n.Webhook.Url != "" {
+			if u, err := url.Parse(n.Webhook.Url); err != nil || u.Scheme == "" || u.Host == "" {
+				v.add("notify.webhook.url", "invalid url %q", n.Webhook.Url)
+			}
+		}
+		if n.Smtp.Host != "" {
+			if n.Smtp.From == "" {
+				v.add("notify.smtp.from", "must not be empty")
+			}
+			if len(n.Smtp.To) == 0 {
+				v.add("notify.smtp.to", "at least one recipient is required")
+			}
+		}
+		if n.Telegram.Token != "" {
+			if u, err := url.Parse(n.Telegram.ApiUrl); err != nil || u.Scheme == "" || u.Host == "" {
+				v.add("notify.telegram.api_url", "invalid url %q", n.Telegram.ApiUrl)
+			}
+			if n.Telegram.ChatId == "" {
+				v.add("notify.telegram.chat_id", "must not be empty")
+			}
+		}
+	}
+
+	if c.Watchdog.Enabled && c.Watchdog.GracePeriod < 0 {
+		v.add("watchdog.grace_period", "must not be negative")
+	}
+
+	if c.Audit.Enabled {
+		if c.Audit.Path == "" {
+			v.add("audit.path", "must not be empty")
+		}
+		if c.Audit.MaxSizeMb < 1 {
+			v.add("audit.max_size_mb", "must be at least 1")
+		}
+		if c.Audit.MaxFiles < 0 {
+			v.add("audit.max_files", "must not be negative")
+		}
+	}
+
+	names := make(map[string]int)
+	for i, b := range c.Batteries {
+		path := fmt.Sprintf("batteries[%d]", i)
+		if b.Name == "" {
+			v.add(path+".name", "must not be empty")
+		} else if j, ok := names[b.Name]; ok {
+			v.add(path+".name", "duplicate battery name %q, already used by batteries[%d]", b.Name, j)
+		} else {
+			names[b.Name] = i
+		}
+		if u, err := url.Parse(b.Url); err != nil || u.Scheme == "" || u.Host == "" {
+			v.add(path+".url", "invalid url %q", b.Url)
+		}
+		switch sources := b.tokenSources(); len(sources) {
+		case 0:
+			v.add(path+".token", "one of token, token_file, token_env or token_credential is required")
+		case 1:
+		default:
+			v.add(path+".token", "only one token source may be set, got %s", strings.Join(sources, ", "))
+		}
+		if !strategy.Known(b.Strategy) {
+			v.add(path+".strategy", "unknown strategy %q", b.Strategy)
+		}
+		validatePercent(v, path+".soc_limit", b.SocLimit)
+		if b.PowerLimit < 0 {
+			v.add(path+".power_limit", "must not be negative")
+		}
+		// forced discharges are capped and stopped by these limits
+		if b.Discharge && b.SocLimit == 0 {
+			v.add(path+".soc_limit", "must be positive with discharge enabled")
+		}
+		if b.Discharge && b.PowerLimit == 0 {
+			v.add(path+".power_limit", "must be positive with discharge enabled")
+		}
+		validateInterval(v, path+".poll_interval", b.PollInterval)
+		if b.AdaptivePolling.Enabled {
+			validateInterval(v, path+".adaptive_polling.fast_interval", b.AdaptivePolling.FastInterval)
+			validateInterval(v, path+".adaptive_polling.slow_interval", b.AdaptivePolling.SlowInterval)
+			if b.AdaptivePolling.FastInterval > b.AdaptivePolling.SlowInterval {
+				v.add(path+".adaptive_polling.fast_interval", "must not be longer than slow_interval")
+			}
+			if b.AdaptivePolling.BoundaryMargin < 0 {
+				v.add(path+".adaptive_polling.boundary_margin", "must not be negative")
+			}
+		}
+		if b.Forecast.Enabled {
+			if b.Forecast.Url == "" && b.Forecast.Path == "" {
+				v.add(path+".forecast", "either url or path is required")
+			}
+			if b.Forecast.Url != "" && b.Forecast.Path != "" {
+				v.add(path+".forecast", "url and path are mutually exclusive")
+			}
+			validatePercent(v, path+".forecast.soc_limit", b.Forecast.SocLimit)
+			if b.Forecast.MinProductionWh < 0 {
+				v.add(path+".forecast.min_production_wh", "must not be negative")
+			}
+		}
+	}
+
+	labels := make(map[string]int)
+	for i, s := range c.Schedules {
+		path := fmt.Sprintf("schedules[%d]", i)
+		// labels identify schedules in commands and the worker state
+		key := s.BatteryName + "/" + s.Label()
+		if j, ok := labels[key]; ok {
+			v.add(path, "duplicate schedule %q of battery %q, already used by schedules[%d], set a unique name",
+				s.Label(), s.BatteryName, j)
+		} else {
+			labels[key] = i
+		}
+		if _, err := timer.ParseClock(s.StartTime); err != nil {
+			v.add(path+".start_time", "invalid time %q, expected HH:MM", s.StartTime)
+		}
+		if _, err := timer.ParseClock(s.StopTime); err != nil {
+			v.add(path+".stop_time", "invalid time %q, expected HH:MM", s.StopTime)
+		}
+		if _, ok := names[s.BatteryName]; !ok {
+			v.add(path+".battery_name", "unknown battery %q", s.BatteryName)
+		}
+		validatePercent(v, path+".soc_limit", s.SocLimit)
+		if s.PowerLimit <= 0 {
+			v.add(path+".power_limit", "must be positive")
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// Warnings returns issues that do not prevent the service from running,
+// such as enabled schedules of one battery overlapping with equal priority.
+func (c *Config) Warnings() []Problem {
+	v := &validator{}
+	if c.Notify.Enabled && c.Notify.DailySummary != "" && !c.Energy.Enabled {
+		v.add("notify.daily_summary", "requires energy.enabled, no summary will be sent")
+	}
+	windows := make([]*timer.Window, len(c.Schedules))
+	for i, s := range c.Schedules {
+		if !s.Enabled {
+			continue
+		}
+		w, err := timer.ParseWindow(s.StartTime, s.StopTime)
+		if err != nil {
+			continue
+		}
+		windows[i] = &w
+	}
+	for i, a := range c.Schedules {
+		for j := i + 1; j < len(c.Schedules); j++ {
+			b := c.Schedules[j]
+			if windows[i] == nil || windows[j] == nil || a.BatteryName != b.BatteryName {
+				continue
+			}
+			if windows[i].Overlaps(*windows[j]) && a.Priority == b.Priority {
+				v.add(fmt.Sprintf("schedules[%d]", i), "overlaps schedules[%d] with equal priority", j)
+			}
+		}
+	}
+	return v.problems
+}
+
+// validateInterval rejects polling intervals that would flood the controller with requests.
+func validateInterval(v *validator, path string, value time.Duration) {
+	if value < time.Second {
+		v.add(path, "must be at least 1s, got %s", value)
+	}
+}
+
+func validatePercent(v *validator, path string, value int) {
+	if value < 0 || value > 100 {
+		v.add(path, "must be between 0 and 100, got %d", value)
+	}
+}
@@ -0,0 +1,128 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only moves when told to. Timers fire, in order of their deadlines,
+// when the clock is advanced past them; like time.Timer their channels hold one value.
+// It is safe for concurrent use.
+type Fake struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	f.schedule(t, d)
+	return t
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t and fires all timers due by then. Moving backwards only
+// changes the time.
+func (f *Fake) Set(t time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = t
+
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].at.Before(f.timers[j].at)
+	})
+	pending := f.timers[:0]
+	for _, timer := range f.timers {
+		if timer.at.After(t) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.active = false
+		select {
+		case timer.c <- timer.at:
+		default:
+		}
+	}
+	f.timers = pending
+}
+
+// Timers returns the number of active timers, e.g. to wait until a goroutine under
+// test has armed its timers before advancing the clock.
+func (f *Fake) Timers() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.timers)
+}
+
+// schedule activates the timer. Must be called with the mutex held.
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) {
+	t.at = f.now.Add(d)
+	t.active = true
+	if d <= 0 {
+		t.active = false
+		select {
+		case t.c <- t.at:
+		default:
+		}
+		return
+	}
+	f.timers = append(f.timers, t)
+}
+
+// unschedule deactivates the timer and reports whether it was active.
+// Must be called with the mutex held.
+func (f *Fake) unschedule(t *fakeTimer) bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, timer := range f.timers {
+		if timer == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	c      chan time.Time
+	at     time.Time
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return active
+}
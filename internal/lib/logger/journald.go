@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	journalSocket     = "/run/systemd/journal/socket"
+	journalIdentifier = "gok-pi"
+)
+
+// journalHandler sends records to journald using its native protocol. Attributes are
+// appended to the message as key=value pairs and sent as fields, e.g. BATTERY=battery1,
+// so that they may be used in journalctl matches.
+type journalHandler struct {
+	conn   *net.UnixConn
+	mutex  *sync.Mutex
+	opts   *slog.HandlerOptions
+	attrs  []slog.Attr
+	prefix string
+}
+
+func newJournalHandler(opts *slog.HandlerOptions) (*journalHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalHandler{conn: conn, mutex: &sync.Mutex{}, opts: opts}, nil
+}
+
+func (h *journalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+func (h *journalHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := append([]slog.Attr{}, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, h.qualified(a)...)
+		return true
+	})
+
+	message := strings.Builder{}
+	message.WriteString(r.Message)
+	for _, a := range attrs {
+		fmt.Fprintf(&message, " %s=%s", a.Key, a.Value)
+	}
+
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", message.String())
+	writeJournalField(&b, "PRIORITY", journalPriority(r.Level))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", journalIdentifier)
+	for _, a := range attrs {
+		name := journalFieldName(a.Key)
+		if name == "MESSAGE" || name == "PRIORITY" || name == "SYSLOG_IDENTIFIER" {
+			name = "ATTR_" + name
+		}
+		writeJournalField(&b, name, a.Value.String())
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		c.attrs = append(c.attrs, h.qualified(a)...)
+	}
+	return &c
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+func (h *journalHandler) Close() error {
+	return h.conn.Close()
+}
+
+// qualified resolves the attribute and flattens groups into dotted keys.
+func (h *journalHandler) qualified(a slog.Attr) []slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return nil
+	}
+	if a.Value.Kind() != slog.KindGroup {
+		a.Key = h.prefix + a.Key
+		return []slog.Attr{a}
+	}
+	group := *h
+	if a.Key != "" {
+		group.prefix = h.prefix + a.Key + "."
+	}
+	var attrs []slog.Attr
+	for _, g := range a.Value.Group() {
+		attrs = append(attrs, group.qualified(g)...)
+	}
+	return attrs
+}
+
+// writeJournalField writes a field in the native protocol; values with newlines are
+// written with an explicit length.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalFieldName turns an attribute key into a valid field name: upper case letters,
+// digits and underscores, not starting with an underscore or digit.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if name == "" {
+		return "FIELD"
+	}
+	return name
+}
+
+func journalPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	default:
+		return "7"
+	}
+}
@@ -2,52 +2,152 @@ package logger
 
 import (
 	"fmt"
-	"log"
+	"gok-pi/internal/lib/rotate"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"time"
 )
 
 const (
-	envLocal    = "local"
-	envDev      = "dev"
-	envProd     = "prod"
-	logFileName = "gok-pi.log"
+	envLocal = "local"
+	envDev   = "dev"
+	envProd  = "prod"
+
+	FormatText = "text"
+	FormatJSON = "json"
+
+	OutputStdout   = "stdout"
+	OutputFile     = "file"
+	OutputJournald = "journald"
 )
 
-func SetupLogger(env, path string) *slog.Logger {
-	var logger *slog.Logger
-	var logFile *os.File
-	var err error
+// Options configure the service log. Empty Level, Format and Output follow the
+// environment: debug text on stdout for local, debug JSON to the file for dev and
+// info JSON to the file for prod. Rotation applies to the file output only.
+type Options struct {
+	Level     string
+	Format    string
+	Output    string
+	Path      string
+	MaxSizeMb int
+	MaxAge    time.Duration
+	MaxFiles  int
+	Compress  bool
+}
+
+// Logger is the service logger. Its level may be changed at runtime.
+type Logger struct {
+	*slog.Logger
+	level      *slog.LevelVar
+	configured slog.Level
+	closer     io.Closer
+}
+
+// New creates the logger for the environment env with the options applied.
+func New(env string, opts Options) (*Logger, error) {
+	defaults, ok := map[string]Options{
+		envLocal: {Level: "debug", Format: FormatText, Output: OutputStdout},
+		envDev:   {Level: "debug", Format: FormatJSON, Output: OutputFile},
+		envProd:  {Level: "info", Format: FormatJSON, Output: OutputFile},
+	}[env]
+	if !ok {
+		return nil, fmt.Errorf("invalid environment: %s", env)
+	}
+	if opts.Level == "" {
+		opts.Level = defaults.Level
+	}
+	if opts.Format == "" {
+		opts.Format = defaults.Format
+	}
+	if opts.Output == "" {
+		opts.Output = defaults.Output
+	}
+
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	l := &Logger{level: new(slog.LevelVar), configured: level}
+	l.level.Set(level)
+	handlerOpts := &slog.HandlerOptions{Level: l.level}
 
-	if env != envLocal {
-		logPath := logFilePath(path)
-		logFile, err = os.OpenFile(logPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	var w io.Writer
+	switch opts.Output {
+	case OutputStdout:
+		w = os.Stdout
+	case OutputFile:
+		file, err := rotate.Open(opts.Path, rotate.Options{
+			MaxSize:  int64(opts.MaxSizeMb) << 20,
+			MaxAge:   opts.MaxAge,
+			MaxFiles: opts.MaxFiles,
+			Compress: opts.Compress,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("opening log file: %w", err)
+		}
+		w, l.closer = file, file
+	case OutputJournald:
+		journal, err := newJournalHandler(handlerOpts)
 		if err != nil {
-			log.Fatal("error opening log file: ", err)
+			return nil, fmt.Errorf("connecting to journald: %w", err)
 		}
-		log.Printf("env: %s; log file: %s", env, logPath)
-	}
-
-	switch env {
-	case envLocal:
-		logger = slog.New(
-			slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
-		)
-	case envDev:
-		logger = slog.New(
-			slog.NewJSONHandler(logFile, &slog.HandlerOptions{Level: slog.LevelDebug}),
-		)
-	case envProd:
-		logger = slog.New(
-			slog.NewJSONHandler(logFile, &slog.HandlerOptions{Level: slog.LevelInfo}),
-		)
+		l.Logger, l.closer = slog.New(journal), journal
+		return l, nil
 	default:
-		log.Fatal("invalid environment: ", env)
+		return nil, fmt.Errorf("invalid output: %s", opts.Output)
 	}
 
-	return logger
+	switch opts.Format {
+	case FormatText:
+		l.Logger = slog.New(slog.NewTextHandler(w, handlerOpts))
+	case FormatJSON:
+		l.Logger = slog.New(slog.NewJSONHandler(w, handlerOpts))
+	default:
+		return nil, fmt.Errorf("invalid format: %s", opts.Format)
+	}
+	return l, nil
+}
+
+// ParseLevel parses debug, info, warn or error, case-insensitively.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// Level returns the current level.
+func (l *Logger) Level() slog.Level {
+	return l.level.Level()
 }
 
-func logFilePath(path string) string {
-	return fmt.Sprintf("%s/%s", path, logFileName)
+// SetLevel changes the level, e.g. on a command received at runtime.
+func (l *Logger) SetLevel(s string) error {
+	level, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	l.level.Set(level)
+	return nil
+}
+
+// ToggleDebug switches between debug and the configured level and returns the new level.
+func (l *Logger) ToggleDebug() slog.Level {
+	level := slog.LevelDebug
+	if l.level.Level() == slog.LevelDebug {
+		level = l.configured
+	}
+	l.level.Set(level)
+	return level
+}
+
+// Close closes the log file or journald connection, if any.
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
 }
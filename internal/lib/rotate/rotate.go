@@ -0,0 +1,200 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const gzipSuffix = ".gz"
+
+// Options control when a File is rotated and which rotated files are kept.
+// MaxSize rotates once a write would exceed it, in bytes; MaxAge rotates once the file
+// has been written to for longer. The age is counted from the modification time of the
+// last rotated file, i.e. when the current file was started, so that it survives restarts.
+// Zero values disable the respective rotation. MaxFiles is the number of rotated files
+// kept; Compress gzips them in the background.
+type Options struct {
+	MaxSize  int64
+	MaxAge   time.Duration
+	MaxFiles int
+	Compress bool
+}
+
+// File is an append-only file that is rotated according to its options: path is renamed
+// to path.1, path.1 to path.2 and so on, with a .gz suffix if compressed, keeping at most
+// MaxFiles rotated files. It is safe for concurrent use.
+type File struct {
+	path        string
+	opts        Options
+	mutex       sync.Mutex
+	file        *os.File
+	size        int64
+	started     time.Time
+	compressing sync.WaitGroup
+	compressErr error // written by the compression, read after waiting for it
+}
+
+// Open opens or creates the file at path for appending, creating its directory if needed.
+func Open(path string, opts Options) (*File, error) {
+	f := &File{path: path, opts: opts}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p, rotating the file first if p would not fit or the file is too old.
+// A single write is never split across files, so line oriented writers keep whole lines.
+func (f *File) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.due(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file after a running compression has finished. It returns
+// the error of the last failed compression, if any.
+func (f *File) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.compressing.Wait()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	if err == nil && f.compressErr != nil {
+		err = fmt.Errorf("compressing %s: %w", f.path, f.compressErr)
+	}
+	return err
+}
+
+func (f *File) due(n int64) bool {
+	if f.opts.MaxSize > 0 && f.size+n > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.MaxAge > 0 && time.Since(f.started) >= f.opts.MaxAge
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("checking %s: %w", f.path, err)
+	}
+	f.file = file
+	f.size = info.Size()
+	f.started = f.startTime(info)
+	return nil
+}
+
+// startTime returns when the current file was started: the modification time of the
+// last rotated file, or, if there is none, that of the current file.
+func (f *File) startTime(current os.FileInfo) time.Time {
+	for _, suffix := range []string{"", gzipSuffix} {
+		if info, err := os.Stat(rotated(f.path, 1) + suffix); err == nil {
+			return info.ModTime()
+		}
+	}
+	return current.ModTime()
+}
+
+// rotate shifts the rotated files, dropping the oldest, and starts a new file.
+// Rotated files are shifted with and without the .gz suffix, so that changing
+// Compress keeps the files written before; a file that failed to compress is kept
+// uncompressed. Must be called with the mutex held.
+func (f *File) rotate() error {
+	// the previous file must be compressed before it is shifted
+	f.compressing.Wait()
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", f.path, err)
+	}
+	f.file = nil
+
+	if f.opts.MaxFiles < 1 {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing %s: %w", f.path, err)
+		}
+		return f.open()
+	}
+	for _, suffix := range []string{"", gzipSuffix} {
+		_ = os.Remove(rotated(f.path, f.opts.MaxFiles) + suffix)
+		for i := f.opts.MaxFiles - 1; i >= 1; i-- {
+			err := os.Rename(rotated(f.path, i)+suffix, rotated(f.path, i+1)+suffix)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("rotating %s: %w", f.path, err)
+			}
+		}
+	}
+	if err := os.Rename(f.path, rotated(f.path, 1)); err != nil {
+		return fmt.Errorf("rotating %s: %w", f.path, err)
+	}
+	if f.opts.Compress {
+		f.compressing.Add(1)
+		go func(path string) {
+			defer f.compressing.Done()
+			if err := compress(path); err != nil {
+				f.compressErr = err
+			}
+		}(rotated(f.path, 1))
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.started = time.Now()
+	return nil
+}
+
+// compress replaces the file at path by its gzipped copy at path.gz.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	dst, err := os.OpenFile(path+gzipSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path + gzipSuffix)
+		return err
+	}
+	return os.Remove(path)
+}
+
+func rotated(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,98 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateBySizeWithCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gok.log")
+	f, err := Open(path, Options{MaxSize: 10, MaxFiles: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first 0001\n", "second 002\n", "third 0003\n", "fourth 004\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		path:           "fourth 004\n",
+		path + ".1.gz": "third 0003\n",
+		path + ".2.gz": "second 002\n",
+	}
+	for name, content := range want {
+		if got := readFile(t, name); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), got, content)
+		}
+	}
+	for _, name := range []string{path + ".1", path + ".3.gz"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s exists, want removed", filepath.Base(name))
+		}
+	}
+}
+
+func TestRotateByAgeSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gok.log")
+	if err := os.WriteFile(path, []byte("current\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".1", []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the current file was started when the previous one was rotated two hours ago
+	rotatedAt := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path+".1", rotatedAt, rotatedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(path, Options{MaxAge: time.Hour, MaxFiles: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{path: "new\n", path + ".1": "current\n", path + ".2": "old\n"} {
+		if got := readFile(t, name); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), got, content)
+		}
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var r io.Reader = file
+	if filepath.Ext(name) == gzipSuffix {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -1,12 +1,106 @@
 package timer
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
-func ParseTime(timeStr string) (time.Time, error) {
-	now := time.Now()
-	parsedTime, err := time.Parse("15:04", timeStr)
+const (
+	clockLayout = "15:04"
+	day         = 24 * time.Hour
+)
+
+// ParseTimeAt returns the "15:04" formatted time on the day of now.
+func ParseTimeAt(now time.Time, timeStr string) (time.Time, error) {
+	parsedTime, err := time.Parse(clockLayout, timeStr)
 	if err != nil {
 		return time.Time{}, err
 	}
 	return time.Date(now.Year(), now.Month(), now.Day(), parsedTime.Hour(), parsedTime.Minute(), 0, 0, now.Location()), nil
 }
+
+// Window is a daily time window given as offsets from midnight.
+// A window with Stop before Start spans midnight; equal offsets denote an empty window.
+type Window struct {
+	Start time.Duration
+	Stop  time.Duration
+}
+
+// ParseClock parses a "15:04" formatted time as an offset from midnight.
+func ParseClock(timeStr string) (time.Duration, error) {
+	parsedTime, err := time.Parse(clockLayout, timeStr)
+	if err != nil {
+		return 0, err
+	}
+	return sinceMidnight(parsedTime), nil
+}
+
+// ParseWindow parses a window from "15:04" formatted start and stop times.
+func ParseWindow(start, stop string) (Window, error) {
+	startOffset, err := ParseClock(start)
+	if err != nil {
+		return Window{}, fmt.Errorf("parsing start time: %w", err)
+	}
+	stopOffset, err := ParseClock(stop)
+	if err != nil {
+		return Window{}, fmt.Errorf("parsing stop time: %w", err)
+	}
+	return Window{Start: startOffset, Stop: stopOffset}, nil
+}
+
+// Contains reports whether t falls within the window, start inclusive.
+func (w Window) Contains(t time.Time) bool {
+	offset := sinceMidnight(t)
+	if w.Start <= w.Stop {
+		return offset >= w.Start && offset < w.Stop
+	}
+	return offset >= w.Start || offset < w.Stop
+}
+
+// NextBoundary returns the first start or stop of the window after t.
+func (w Window) NextBoundary(t time.Time) time.Time {
+	next := at(t, w.Start)
+	if stop := at(t, w.Stop); stop.Before(next) {
+		next = stop
+	}
+	return next
+}
+
+// at returns the first time after t with the given wall clock offset from midnight.
+// The time is built from its clock fields rather than added to midnight, so that it
+// keeps its wall clock time on days with a daylight saving time change.
+func at(t time.Time, offset time.Duration) time.Time {
+	hour, minute := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+	next := time.Date(t.Year(), t.Month(), t.Day(), hour, minute, 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(t.Year(), t.Month(), t.Day()+1, hour, minute, 0, 0, t.Location())
+	}
+	return next
+}
+
+// Overlaps reports whether both windows share any time of the day.
+func (w Window) Overlaps(o Window) bool {
+	for _, a := range w.spans() {
+		for _, b := range o.spans() {
+			if a.Start < b.Stop && b.Start < a.Stop {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spans splits the window into ranges that do not cross midnight.
+func (w Window) spans() []Window {
+	switch {
+	case w.Start == w.Stop:
+		return nil
+	case w.Start < w.Stop:
+		return []Window{w}
+	}
+	return []Window{{Start: w.Start, Stop: day}, {Start: 0, Stop: w.Stop}}
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
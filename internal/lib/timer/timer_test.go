@@ -0,0 +1,84 @@
+package timer
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestNextBoundary(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(s string) time.Time {
+		t.Helper()
+		d, err := time.ParseInLocation(time.DateTime, s, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name        string
+		start, stop string
+		now         string
+		want        string
+	}{
+		{"start later today", "20:00", "22:00", "2024-08-14 19:00:00", "2024-08-14 20:00:00"},
+		{"stop within window", "20:00", "22:00", "2024-08-14 20:00:00", "2024-08-14 22:00:00"},
+		{"start tomorrow", "20:00", "22:00", "2024-08-14 22:00:00", "2024-08-15 20:00:00"},
+		{"across midnight, stop after midnight", "22:00", "02:00", "2024-08-14 23:00:00", "2024-08-15 02:00:00"},
+		{"across midnight, within early part", "22:00", "02:00", "2024-08-15 01:00:00", "2024-08-15 02:00:00"},
+		{"across midnight, start today", "22:00", "02:00", "2024-08-15 02:00:00", "2024-08-15 22:00:00"},
+		{"spring forward, after the gap", "20:00", "22:00", "2024-03-31 12:00:00", "2024-03-31 20:00:00"},
+		{"spring forward, from before the gap", "20:00", "22:00", "2024-03-31 01:00:00", "2024-03-31 20:00:00"},
+		{"spring forward, across midnight", "22:00", "04:00", "2024-03-30 23:00:00", "2024-03-31 04:00:00"},
+		{"fall back, start after the change", "20:00", "22:00", "2024-10-27 19:00:00", "2024-10-27 20:00:00"},
+		{"fall back, from before the change", "20:00", "22:00", "2024-10-27 01:00:00", "2024-10-27 20:00:00"},
+		{"fall back, across midnight", "22:00", "04:00", "2024-10-26 23:00:00", "2024-10-27 04:00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseWindow(tt.start, tt.stop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := w.NextBoundary(date(tt.now))
+			if want := date(tt.want); !got.Equal(want) {
+				t.Errorf("NextBoundary(%s) = %s, want %s", tt.now, got, want)
+			}
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		start, stop string
+		at          string
+		want        bool
+	}{
+		{"20:00", "22:00", "20:00", true},
+		{"20:00", "22:00", "22:00", false},
+		{"20:00", "22:00", "19:59", false},
+		{"22:00", "02:00", "23:30", true},
+		{"22:00", "02:00", "01:59", true},
+		{"22:00", "02:00", "02:00", false},
+		{"22:00", "02:00", "12:00", false},
+		{"20:00", "20:00", "20:00", false},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.start, tt.stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now, err := ParseTimeAt(time.Date(2024, 8, 14, 0, 0, 0, 0, time.UTC), tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Contains(now); got != tt.want {
+			t.Errorf("%s-%s contains %s = %v, want %v", tt.start, tt.stop, tt.at, got, tt.want)
+		}
+	}
+}
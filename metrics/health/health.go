@@ -0,0 +1,146 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// pollBudget is the time a single poll may take including the API retries; a worker that
+// has not finished a poll within its ready intervals and this budget is considered hung.
+const pollBudget = 2 * time.Minute
+
+// Registry tracks the polling state of the battery workers to answer readiness checks.
+// It is safe for concurrent use.
+type Registry struct {
+	intervals   int
+	mutex       sync.Mutex
+	batteries   map[string]*battery
+	configCheck func() error
+}
+
+type battery struct {
+	interval  time.Duration
+	lastPoll  time.Time
+	lastSeen  time.Time
+	lastError string
+}
+
+// BatteryReport is the readiness detail of a single battery.
+type BatteryReport struct {
+	Ready           bool       `json:"ready"`
+	LastPoll        *time.Time `json:"last_poll,omitempty"`
+	AgeSeconds      float64    `json:"age_seconds,omitempty"`
+	IntervalSeconds float64    `json:"interval_seconds"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// Report is the readiness of the service and each enabled battery. Config is "valid",
+// "invalid" with the problems in ConfigError, or "unchecked" without a config check.
+type Report struct {
+	Ready       bool                     `json:"ready"`
+	Config      string                   `json:"config"`
+	ConfigError string                   `json:"config_error,omitempty"`
+	Batteries   map[string]BatteryReport `json:"batteries"`
+}
+
+// New creates a registry considering a battery ready if it was polled successfully
+// within the given number of its polling intervals.
+func New(intervals int) *Registry {
+	return &Registry{
+		intervals: intervals,
+		batteries: make(map[string]*battery),
+	}
+}
+
+// SetConfigCheck sets the check of the configuration file reported by Check, e.g. to
+// notice edits that would prevent the service from starting again.
+func (r *Registry) SetConfigCheck(check func() error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.configCheck = check
+}
+
+// Register adds a battery polled at most every interval, registered at t.
+func (r *Registry) Register(name string, interval time.Duration, t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.batteries[name] = &battery{interval: interval, lastSeen: t}
+}
+
+func (r *Registry) PollSucceeded(name string, t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if b, ok := r.batteries[name]; ok {
+		b.lastPoll = t
+		b.lastSeen = t
+		b.lastError = ""
+	}
+}
+
+func (r *Registry) PollFailed(name string, t time.Time, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if b, ok := r.batteries[name]; ok {
+		b.lastSeen = t
+		b.lastError = err.Error()
+	}
+}
+
+// Alive reports whether every registered battery worker has finished a poll, successful
+// or not, within its ready intervals and the poll budget. Before the first poll the time
+// is counted from the registration, so that a worker hanging on its first poll is noticed.
+func (r *Registry) Alive(now time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, b := range r.batteries {
+		if now.Sub(b.lastSeen) > time.Duration(r.intervals)*b.interval+pollBudget {
+			return false
+		}
+	}
+	return true
+}
+
+// Check reports whether the config check passes and every registered battery was polled
+// successfully recently. The service is not ready before the workers have registered.
+func (r *Registry) Check(now time.Time) Report {
+	r.mutex.Lock()
+	check := r.configCheck
+	r.mutex.Unlock()
+
+	// the check reads the config file, run it without blocking the workers
+	config, configError := "unchecked", ""
+	if check != nil {
+		config = "valid"
+		if err := check(); err != nil {
+			config, configError = "invalid", err.Error()
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := Report{
+		Ready:       len(r.batteries) > 0 && config != "invalid",
+		Config:      config,
+		ConfigError: configError,
+		Batteries:   make(map[string]BatteryReport, len(r.batteries)),
+	}
+	for name, b := range r.batteries {
+		br := BatteryReport{
+			IntervalSeconds: b.interval.Seconds(),
+			Error:           b.lastError,
+		}
+		if !b.lastPoll.IsZero() {
+			lastPoll := b.lastPoll
+			age := now.Sub(lastPoll)
+			br.LastPoll = &lastPoll
+			br.AgeSeconds = age.Seconds()
+			br.Ready = age <= time.Duration(r.intervals)*b.interval
+		}
+		if !br.Ready {
+			report.Ready = false
+		}
+		report.Batteries[name] = br
+	}
+	return report
+}
@@ -0,0 +1,86 @@
+package health
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckReportsConfig(t *testing.T) {
+	r := New(6)
+	now := time.Now()
+	r.Register("b1", 10*time.Second, now)
+	r.PollSucceeded("b1", now)
+
+	if report := r.Check(now); report.Config != "unchecked" || !report.Ready {
+		t.Errorf("report = %+v, want ready and unchecked config", report)
+	}
+	r.SetConfigCheck(func() error { return nil })
+	if report := r.Check(now); report.Config != "valid" {
+		t.Errorf("config = %q, want valid", report.Config)
+	}
+	r.SetConfigCheck(func() error { return errors.New("env: unknown environment") })
+	report := r.Check(now)
+	if report.Config != "invalid" || report.ConfigError != "env: unknown environment" || report.Ready {
+		t.Errorf("report = %+v, want not ready with invalid config", report)
+	}
+}
+
+func TestAlive(t *testing.T) {
+	r := New(6)
+	start := time.Now()
+	r.Register("b1", 10*time.Second, start)
+	if !r.Alive(start) {
+		t.Error("worker without polls not alive")
+	}
+	if r.Alive(start.Add(time.Minute + pollBudget + time.Second)) {
+		t.Error("worker hanging on its first poll alive")
+	}
+	polled := start.Add(5 * time.Minute)
+	r.PollFailed("b1", polled, errors.New("api unavailable"))
+	if !r.Alive(polled.Add(time.Minute)) {
+		t.Error("worker with a recent failed poll not alive")
+	}
+	if r.Alive(polled.Add(time.Minute + pollBudget + time.Second)) {
+		t.Error("worker without polls beyond the budget alive")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if err := Notify("WATCHDOG=1"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "WATCHDOG=1" {
+		t.Errorf("notification = %q, want WATCHDOG=1", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("interval = %s, want 30s", got)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("interval for another pid = %s, want 0", got)
+	}
+}
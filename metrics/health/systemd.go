@@ -0,0 +1,70 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"gok-pi/internal/lib/sl"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify sends a state such as READY=1 to systemd if the service was started with
+// Type=notify, i.e. NOTIFY_SOCKET is set; it does nothing otherwise.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// abstract socket namespace
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("connecting to systemd: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("notifying systemd: %w", err)
+	}
+	return nil
+}
+
+// WatchdogInterval returns the interval within which systemd expects WATCHDOG=1, as set by
+// WatchdogSec=, or zero if the watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog sends WATCHDOG=1 at half the interval while every battery worker is alive,
+// so that systemd restarts the service if a worker hangs, until ctx is done.
+func (r *Registry) RunWatchdog(ctx context.Context, interval time.Duration, log *slog.Logger) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !r.Alive(now) {
+				log.Warn("battery worker not polling, skipping watchdog notification")
+				continue
+			}
+			if err := Notify("WATCHDOG=1"); err != nil {
+				log.With(sl.Err(err)).Warn("sending watchdog notification")
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package observers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+type controlMetrics struct {
+	apiDuration      *prometheus.HistogramVec
+	apiRetries       *prometheus.CounterVec
+	apiFailures      *prometheus.CounterVec
+	dischargeCommand *prometheus.CounterVec
+	modeSwitch       *prometheus.CounterVec
+	activeSchedule   *prometheus.GaugeVec
+	lastPoll         *prometheus.GaugeVec
+	stuckManual      *prometheus.GaugeVec
+	watchdogRecovery *prometheus.CounterVec
+	stateTransition  *prometheus.CounterVec
+	controlState     *prometheus.GaugeVec
+}
+
+func newControlMetrics(reg prometheus.Registerer) *controlMetrics {
+	apiDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gok",
+		Name:      "api_request_duration_seconds",
+		Help:      "Battery API request latency by endpoint and HTTP status, \"error\" if no response was received",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}, []string{"name", "endpoint", "status"})
+	reg.MustRegister(apiDuration)
+
+	return &controlMetrics{
+		apiDuration: apiDuration,
+		apiRetries: counterVec(reg, "gok", "api_retries_total",
+			"Battery API requests retried after a failed attempt", "name", "endpoint"),
+		apiFailures: counterVec(reg, "gok", "api_failures_total",
+			"Battery API requests failed after all retries", "name", "endpoint"),
+		dischargeCommand: counterVec(reg, "gok", "discharge_commands_total",
+			"Discharge commands sent to the battery: start, stop or power change", "name", "command", "result"),
+		modeSwitch: counterVec(reg, "gok", "mode_switches_total",
+			"Operating mode switches requested: manual or auto", "name", "mode", "result"),
+		activeSchedule: gaugeVec(reg, "gok", "active_schedule",
+			"Schedule in effect as a label, always 1; \"none\" outside any schedule", "name", "schedule"),
+		lastPoll: gaugeVec(reg, "gok", "last_successful_poll_timestamp_seconds",
+			"Unix time of the last successful battery status request", "name"),
+		stuckManual: gaugeVec(reg, "gok", "stuck_manual_mode",
+			"1 while the battery is in manual mode outside any schedule without being discharged by gok-pi", "name"),
+		watchdogRecovery: counterVec(reg, "gok", "watchdog_recoveries_total",
+			"Switches back to automatic mode by the stuck-in-manual watchdog", "name", "result"),
+		stateTransition: counterVec(reg, "gok", "state_transitions_total",
+			"Transitions of the discharge control state machine", "name", "from", "to"),
+		controlState: gaugeVec(reg, "gok", "control_state",
+			"Current discharge control state as a label, always 1", "name", "state"),
+	}
+}
+
+func (p *Prometheus) ObserveApiRequest(name, endpoint, status string, duration time.Duration) {
+	p.control.apiDuration.WithLabelValues(name, endpoint, status).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) IncApiRetry(name, endpoint string) {
+	p.control.apiRetries.WithLabelValues(name, endpoint).Inc()
+}
+
+func (p *Prometheus) IncApiFailure(name, endpoint string) {
+	p.control.apiFailures.WithLabelValues(name, endpoint).Inc()
+}
+
+func (p *Prometheus) IncDischargeCommand(name, command string, err error) {
+	p.control.dischargeCommand.WithLabelValues(name, command, result(err)).Inc()
+}
+
+func (p *Prometheus) IncModeSwitch(name, mode string, err error) {
+	p.control.modeSwitch.WithLabelValues(name, mode, result(err)).Inc()
+}
+
+func (p *Prometheus) UpdateActiveSchedule(name, schedule string) {
+	p.control.activeSchedule.DeletePartialMatch(prometheus.Labels{"name": name})
+	p.control.activeSchedule.WithLabelValues(name, schedule).Set(1)
+}
+
+func (p *Prometheus) UpdateLastPoll(name string, t time.Time) {
+	p.control.lastPoll.WithLabelValues(name).Set(float64(t.Unix()))
+}
+
+func (p *Prometheus) UpdateStuckManual(name string, stuck bool) {
+	p.control.stuckManual.WithLabelValues(name).Set(boolValue(stuck))
+}
+
+func (p *Prometheus) IncWatchdogRecovery(name string, err error) {
+	p.control.watchdogRecovery.WithLabelValues(name, result(err)).Inc()
+}
+
+func (p *Prometheus) IncStateTransition(name, from, to string) {
+	p.control.stateTransition.WithLabelValues(name, from, to).Inc()
+}
+
+func (p *Prometheus) UpdateControlState(name, state string) {
+	p.control.controlState.DeletePartialMatch(prometheus.Labels{"name": name})
+	p.control.controlState.WithLabelValues(name, state).Set(1)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
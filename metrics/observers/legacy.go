@@ -0,0 +1,46 @@
+package observers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gok-pi/battery/entity"
+	"strconv"
+)
+
+// legacyMetrics are the status gauges under their original names,
+// exported only with the legacy names compatibility flag.
+type legacyMetrics struct {
+	soc            *prometheus.GaugeVec
+	uSoc           *prometheus.GaugeVec
+	capacity       *prometheus.GaugeVec
+	consumption    *prometheus.GaugeVec
+	pac            *prometheus.GaugeVec
+	dischargeState *prometheus.GaugeVec
+	opMode         *prometheus.GaugeVec
+}
+
+func newLegacyMetrics(reg prometheus.Registerer) *legacyMetrics {
+	gauge := func(name, help string) *prometheus.GaugeVec {
+		return gaugeVec(reg, "battery", name, help+" (deprecated)", "name")
+	}
+	return &legacyMetrics{
+		soc:            gauge("RSoC", "Relative state of charge in percent"),
+		uSoc:           gauge("USoC", "User state of charge in percent"),
+		capacity:       gauge("RemainingCapacity_W", "Remaining capacity based on RSoC"),
+		consumption:    gauge("Consumption_W", "House consumption in Watts, direct measurement"),
+		pac:            gauge("Pac_total_W", "AC Power: greater than zero - discharging, less than zero - charging in Watts"),
+		dischargeState: gauge("BatteryDischarging", "Discharge status: 1 - discharging, 0 - not discharging"),
+		opMode:         gauge("BatteryOperatingMode", "Operating mode: 1 - manual, 2 - auto"),
+	}
+}
+
+func (m *legacyMetrics) update(name string, status *entity.SystemStatus) {
+	m.soc.WithLabelValues(name).Set(status.RSOC)
+	m.uSoc.WithLabelValues(name).Set(status.USOC)
+	m.capacity.WithLabelValues(name).Set(status.RemainingCapacityWh)
+	m.consumption.WithLabelValues(name).Set(status.ConsumptionW)
+	m.pac.WithLabelValues(name).Set(status.PacTotalW)
+	m.dischargeState.WithLabelValues(name).Set(boolValue(status.BatteryDischarging))
+	if mode, err := strconv.ParseFloat(status.OperatingMode, 64); err == nil {
+		m.opMode.WithLabelValues(name).Set(mode)
+	}
+}
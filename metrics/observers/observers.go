@@ -2,84 +2,97 @@ package observers
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"strconv"
+	"gok-pi/battery/entity"
+	"time"
 )
 
-var socGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Namespace: "battery",
-	Name:      "RSoC",
-	Help:      "Relative state of charge in percent",
-}, []string{"name"})
-
-func UpdateSoC(name string, value float64) {
-	socGauge.WithLabelValues(name).Set(value)
+// Observer receives battery and control loop measurements.
+type Observer interface {
+	// UpdateStatus exports all fields of a polled battery status.
+	UpdateStatus(name string, status *entity.SystemStatus)
+	AddEnergy(name, schedule string, discharged, charged float64)
+	ObserveApiRequest(name, endpoint, status string, duration time.Duration)
+	IncApiRetry(name, endpoint string)
+	IncApiFailure(name, endpoint string)
+	IncDischargeCommand(name, command string, err error)
+	IncModeSwitch(name, mode string, err error)
+	UpdateActiveSchedule(name, schedule string)
+	UpdateLastPoll(name string, t time.Time)
+	UpdateStuckManual(name string, stuck bool)
+	IncWatchdogRecovery(name string, err error)
+	IncStateTransition(name, from, to string)
+	UpdateControlState(name, state string)
 }
 
-var uSocGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Namespace: "battery",
-	Name:      "USoC",
-	Help:      "User state of charge in percent",
-}, []string{"name"})
-
-func UpdateUSoC(name string, value float64) {
-	uSocGauge.WithLabelValues(name).Set(value)
+// Prometheus exports measurements as Prometheus metrics registered on its own registry,
+// so that several instances can coexist, e.g. in tests.
+type Prometheus struct {
+	status  *statusMetrics
+	legacy  *legacyMetrics
+	control *controlMetrics
 }
 
-var capacityGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Namespace: "battery",
-	Name:      "RemainingCapacity_W",
-	Help:      "Remaining capacity based on RSoC",
-}, []string{"name"})
-
-func UpdateCapacity(name string, value float64) {
-	capacityGauge.WithLabelValues(name).Set(value)
+// New creates an observer and registers its metrics on reg. With legacyNames the status
+// gauges are additionally exported under the names used before the metrics were renamed,
+// e.g. battery_RSoC for battery_rsoc_percent, to keep existing dashboards working.
+func New(reg prometheus.Registerer, legacyNames bool) *Prometheus {
+	p := &Prometheus{
+		status:  newStatusMetrics(reg),
+		control: newControlMetrics(reg),
+	}
+	if legacyNames {
+		p.legacy = newLegacyMetrics(reg)
+	}
+	return p
 }
 
-var consumptionGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Namespace: "battery",
-	Name:      "Consumption_W",
-	Help:      "House consumption in Watts, direct measurement",
-}, []string{"name"})
-
-func UpdateConsumption(name string, value float64) {
-	consumptionGauge.WithLabelValues(name).Set(value)
+func (p *Prometheus) UpdateStatus(name string, status *entity.SystemStatus) {
+	p.status.update(name, status)
+	if p.legacy != nil {
+		p.legacy.update(name, status)
+	}
 }
 
-var pacGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Namespace: "battery",
-	Name:      "Pac_total_W",
-	Help:      "AC Power: greater than zero - discharging, less than zero - charging in Watts",
-}, []string{"name"})
-
-func UpdatePac(name string, value float64) {
-	pacGauge.WithLabelValues(name).Set(value)
-}
+// Nop discards all measurements.
+type Nop struct{}
 
-var dischargeStateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Namespace: "battery",
-	Name:      "BatteryDischarging",
-	Help:      "Discharge status: 1 - discharging, 0 - not discharging",
-}, []string{"name"})
+func (Nop) UpdateStatus(string, *entity.SystemStatus)               {}
+func (Nop) AddEnergy(string, string, float64, float64)              {}
+func (Nop) ObserveApiRequest(string, string, string, time.Duration) {}
+func (Nop) IncApiRetry(string, string)                              {}
+func (Nop) IncApiFailure(string, string)                            {}
+func (Nop) IncDischargeCommand(string, string, error)               {}
+func (Nop) IncModeSwitch(string, string, error)                     {}
+func (Nop) UpdateActiveSchedule(string, string)                     {}
+func (Nop) UpdateLastPoll(string, time.Time)                        {}
+func (Nop) UpdateStuckManual(string, bool)                          {}
+func (Nop) IncWatchdogRecovery(string, error)                       {}
+func (Nop) IncStateTransition(string, string, string)               {}
+func (Nop) UpdateControlState(string, string)                       {}
 
-func UpdateDischargeState(name string, state bool) {
-	if state {
-		dischargeStateGauge.WithLabelValues(name).Set(1.0)
-	} else {
-		dischargeStateGauge.WithLabelValues(name).Set(0.0)
-	}
+func gaugeVec(reg prometheus.Registerer, namespace, name, help string, labels ...string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, labels)
+	reg.MustRegister(g)
+	return g
 }
 
-var opModeGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Namespace: "battery",
-	Name:      "BatteryOperatingMode",
-	Help:      "Operating mode: 1 - manual, 2 - auto",
-}, []string{"name"})
+func counterVec(reg prometheus.Registerer, namespace, name, help string, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, labels)
+	reg.MustRegister(c)
+	return c
+}
 
-func UpdateOpMode(name string, value string) {
-	state, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		return
+func boolValue(b bool) float64 {
+	if b {
+		return 1.0
 	}
-	opModeGauge.WithLabelValues(name).Set(state)
+	return 0.0
 }
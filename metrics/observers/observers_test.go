@@ -0,0 +1,162 @@
+package observers
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gok-pi/battery/entity"
+	"strings"
+	"testing"
+)
+
+func testStatus() *entity.SystemStatus {
+	return &entity.SystemStatus{
+		RSOC:                55,
+		USOC:                50,
+		RemainingCapacityWh: 5500,
+		ConsumptionW:        420,
+		PacTotalW:           -800,
+		OperatingMode:       "2",
+		BatteryCharging:     true,
+		SystemStatus:        "OnGrid",
+		FlowGridBattery:     true,
+	}
+}
+
+func TestStatusGauges(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := New(reg, false)
+	p.UpdateStatus("b1", testStatus())
+
+	tests := []struct {
+		name      string
+		collector prometheus.Collector
+		want      float64
+	}{
+		{"rsoc", p.status.rsoc.WithLabelValues("b1"), 55},
+		{"usoc", p.status.usoc.WithLabelValues("b1"), 50},
+		{"remaining capacity", p.status.remainingCapacity.WithLabelValues("b1"), 5500},
+		{"ac power", p.status.acPower.WithLabelValues("b1"), -800},
+		{"operating mode", p.status.operatingMode.WithLabelValues("b1"), 2},
+		{"charging", p.status.charging.WithLabelValues("b1"), 1},
+		{"discharging", p.status.discharging.WithLabelValues("b1"), 0},
+		{"grid battery flow", p.status.flow.WithLabelValues("b1", "grid_battery"), 1},
+		{"production grid flow", p.status.flow.WithLabelValues("b1", "production_grid"), 0},
+		{"system info", p.status.systemInfo.WithLabelValues("b1", "OnGrid"), 1},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(tt.collector); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if n := testutil.CollectAndCount(p.status.systemInfo); n != 1 {
+		t.Errorf("system info series = %d, want 1", n)
+	}
+
+	p.AddEnergy("b1", "night", 120, 0)
+	p.AddEnergy("b1", "night", 30, 5)
+	if got := testutil.ToFloat64(p.status.energyDischarged.WithLabelValues("b1", "night")); got != 150 {
+		t.Errorf("discharged = %v, want 150", got)
+	}
+	if got := testutil.ToFloat64(p.status.energyCharged.WithLabelValues("b1", "night")); got != 5 {
+		t.Errorf("charged = %v, want 5", got)
+	}
+}
+
+func TestLegacyNames(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := New(reg, true)
+	p.UpdateStatus("b1", testStatus())
+
+	want := `
+# HELP battery_RSoC Relative state of charge in percent (deprecated)
+# TYPE battery_RSoC gauge
+battery_RSoC{name="b1"} 55
+# HELP battery_USoC User state of charge in percent (deprecated)
+# TYPE battery_USoC gauge
+battery_USoC{name="b1"} 50
+# HELP battery_Pac_total_W AC Power: greater than zero - discharging, less than zero - charging in Watts (deprecated)
+# TYPE battery_Pac_total_W gauge
+battery_Pac_total_W{name="b1"} -800
+# HELP battery_BatteryOperatingMode Operating mode: 1 - manual, 2 - auto (deprecated)
+# TYPE battery_BatteryOperatingMode gauge
+battery_BatteryOperatingMode{name="b1"} 2
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"battery_RSoC", "battery_USoC", "battery_Pac_total_W", "battery_BatteryOperatingMode")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLegacyNamesDisabled(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := New(reg, false)
+	p.UpdateStatus("b1", testStatus())
+
+	n, err := testutil.GatherAndCount(reg, "battery_RSoC", "battery_USoC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("legacy series = %d, want none", n)
+	}
+	if n, _ := testutil.GatherAndCount(reg, "battery_rsoc_percent"); n != 1 {
+		t.Errorf("rsoc series = %d, want 1", n)
+	}
+}
+
+func TestControlState(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := New(reg, false)
+
+	p.UpdateControlState("b1", "idle")
+	p.UpdateControlState("b2", "idle")
+	p.IncStateTransition("b1", "idle", "switching_to_manual")
+	p.UpdateControlState("b1", "switching_to_manual")
+	p.IncStateTransition("b1", "switching_to_manual", "discharging")
+	p.UpdateControlState("b1", "discharging")
+
+	want := `
+# HELP gok_control_state Current discharge control state as a label, always 1
+# TYPE gok_control_state gauge
+gok_control_state{name="b1",state="discharging"} 1
+gok_control_state{name="b2",state="idle"} 1
+# HELP gok_state_transitions_total Transitions of the discharge control state machine
+# TYPE gok_state_transitions_total counter
+gok_state_transitions_total{from="idle",name="b1",to="switching_to_manual"} 1
+gok_state_transitions_total{from="switching_to_manual",name="b1",to="discharging"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"gok_control_state", "gok_state_transitions_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestControlCounters(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := New(reg, false)
+
+	p.IncModeSwitch("b1", "manual", nil)
+	p.IncModeSwitch("b1", "manual", errors.New("api unavailable"))
+	p.IncDischargeCommand("b1", "start", nil)
+	p.UpdateActiveSchedule("b1", "night")
+	p.UpdateActiveSchedule("b1", "none")
+
+	want := `
+# HELP gok_mode_switches_total Operating mode switches requested: manual or auto
+# TYPE gok_mode_switches_total counter
+gok_mode_switches_total{mode="manual",name="b1",result="error"} 1
+gok_mode_switches_total{mode="manual",name="b1",result="ok"} 1
+# HELP gok_active_schedule Schedule in effect as a label, always 1; "none" outside any schedule
+# TYPE gok_active_schedule gauge
+gok_active_schedule{name="b1",schedule="none"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"gok_mode_switches_total", "gok_active_schedule"); err != nil {
+		t.Error(err)
+	}
+	if got := testutil.ToFloat64(p.control.dischargeCommand.WithLabelValues("b1", "start", "ok")); got != 1 {
+		t.Errorf("discharge commands = %v, want 1", got)
+	}
+}
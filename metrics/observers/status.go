@@ -0,0 +1,128 @@
+package observers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gok-pi/battery/entity"
+	"strconv"
+	"time"
+)
+
+// controllerTimeLayout is the format of SystemStatus.Timestamp, in the controller's local time.
+const controllerTimeLayout = "2006-01-02 15:04:05"
+
+type statusMetrics struct {
+	rsoc                *prometheus.GaugeVec
+	usoc                *prometheus.GaugeVec
+	remainingCapacity   *prometheus.GaugeVec
+	consumption         *prometheus.GaugeVec
+	consumptionAvg      *prometheus.GaugeVec
+	acPower             *prometheus.GaugeVec
+	production          *prometheus.GaugeVec
+	gridFeedIn          *prometheus.GaugeVec
+	apparentOutput      *prometheus.GaugeVec
+	sac1                *prometheus.GaugeVec
+	acVoltage           *prometheus.GaugeVec
+	dcVoltage           *prometheus.GaugeVec
+	acFrequency         *prometheus.GaugeVec
+	backupBuffer        *prometheus.GaugeVec
+	systemInstalled     *prometheus.GaugeVec
+	operatingMode       *prometheus.GaugeVec
+	charging            *prometheus.GaugeVec
+	discharging         *prometheus.GaugeVec
+	dischargeNotAllowed *prometheus.GaugeVec
+	generatorAutostart  *prometheus.GaugeVec
+	timestampAge        *prometheus.GaugeVec
+	flow                *prometheus.GaugeVec
+	systemInfo          *prometheus.GaugeVec
+	energyDischarged    *prometheus.CounterVec
+	energyCharged       *prometheus.CounterVec
+}
+
+func newStatusMetrics(reg prometheus.Registerer) *statusMetrics {
+	gauge := func(name, help string) *prometheus.GaugeVec {
+		return gaugeVec(reg, "battery", name, help, "name")
+	}
+	return &statusMetrics{
+		rsoc:                gauge("rsoc_percent", "Relative state of charge in percent"),
+		usoc:                gauge("usoc_percent", "User state of charge in percent"),
+		remainingCapacity:   gauge("remaining_capacity_wh", "Remaining capacity based on RSoC in Wh"),
+		consumption:         gauge("consumption_watts", "House consumption in Watts, direct measurement"),
+		consumptionAvg:      gauge("consumption_average_watts", "Average house consumption in Watts"),
+		acPower:             gauge("ac_power_watts", "AC Power: greater than zero - discharging, less than zero - charging in Watts"),
+		production:          gauge("production_watts", "PV production in Watts"),
+		gridFeedIn:          gauge("grid_feed_in_watts", "Grid feed in (positive) or purchase (negative) in Watts"),
+		apparentOutput:      gauge("apparent_output_voltamperes", "Apparent AC output in VA"),
+		sac1:                gauge("sac1_voltamperes", "Apparent power on phase 1 in VA"),
+		acVoltage:           gauge("ac_voltage_volts", "AC voltage in Volts"),
+		dcVoltage:           gauge("dc_voltage_volts", "Battery DC voltage in Volts"),
+		acFrequency:         gauge("ac_frequency_hertz", "AC frequency in Hertz"),
+		backupBuffer:        gauge("backup_buffer_percent", "SoC reserved for backup power in percent"),
+		systemInstalled:     gauge("system_installed", "System installed: 1 - yes, 0 - no"),
+		operatingMode:       gauge("operating_mode", "Operating mode: 1 - manual, 2 - auto"),
+		charging:            gauge("charging", "Charge status: 1 - charging, 0 - not charging"),
+		discharging:         gauge("discharging", "Discharge status: 1 - discharging, 0 - not discharging"),
+		dischargeNotAllowed: gauge("discharge_not_allowed", "Discharge blocked by the controller: 1 - blocked, 0 - allowed"),
+		generatorAutostart:  gauge("generator_autostart", "Generator autostart: 1 - enabled, 0 - disabled"),
+		timestampAge:        gauge("status_timestamp_age_seconds", "Age of the controller status timestamp in seconds"),
+		flow: gaugeVec(reg, "battery", "flow",
+			"Energy flow between components: 1 - active, 0 - inactive", "name", "flow"),
+		systemInfo: gaugeVec(reg, "battery", "system_info",
+			"Controller system status as a label, always 1", "name", "system_status"),
+		energyDischarged: counterVec(reg, "battery", "discharged_wh_total",
+			"Energy delivered by the battery in Wh, integrated from Pac_total_W", "name", "schedule"),
+		energyCharged: counterVec(reg, "battery", "charged_wh_total",
+			"Energy stored by the battery in Wh, integrated from Pac_total_W", "name", "schedule"),
+	}
+}
+
+func (m *statusMetrics) update(name string, status *entity.SystemStatus) {
+	m.rsoc.WithLabelValues(name).Set(status.RSOC)
+	m.usoc.WithLabelValues(name).Set(status.USOC)
+	m.remainingCapacity.WithLabelValues(name).Set(status.RemainingCapacityWh)
+	m.consumption.WithLabelValues(name).Set(status.ConsumptionW)
+	m.consumptionAvg.WithLabelValues(name).Set(status.ConsumptionAvg)
+	m.acPower.WithLabelValues(name).Set(status.PacTotalW)
+	m.production.WithLabelValues(name).Set(status.ProductionW)
+	m.gridFeedIn.WithLabelValues(name).Set(status.GridFeedInW)
+	m.apparentOutput.WithLabelValues(name).Set(status.ApparentOutput)
+	m.sac1.WithLabelValues(name).Set(status.Sac1)
+	m.acVoltage.WithLabelValues(name).Set(status.Uac)
+	m.dcVoltage.WithLabelValues(name).Set(status.Ubat)
+	m.acFrequency.WithLabelValues(name).Set(status.Fac)
+	m.systemInstalled.WithLabelValues(name).Set(status.IsSystemInstalled)
+	if backup, err := strconv.ParseFloat(status.BackupBuffer, 64); err == nil {
+		m.backupBuffer.WithLabelValues(name).Set(backup)
+	}
+	if mode, err := strconv.ParseFloat(status.OperatingMode, 64); err == nil {
+		m.operatingMode.WithLabelValues(name).Set(mode)
+	}
+
+	m.charging.WithLabelValues(name).Set(boolValue(status.BatteryCharging))
+	m.discharging.WithLabelValues(name).Set(boolValue(status.BatteryDischarging))
+	m.dischargeNotAllowed.WithLabelValues(name).Set(boolValue(status.DischargeNotAllowed))
+	m.generatorAutostart.WithLabelValues(name).Set(boolValue(status.GeneratorAutostart))
+
+	flows := map[string]bool{
+		"consumption_battery":    status.FlowConsumptionBattery,
+		"consumption_grid":       status.FlowConsumptionGrid,
+		"consumption_production": status.FlowConsumptionProduction,
+		"grid_battery":           status.FlowGridBattery,
+		"production_battery":     status.FlowProductionBattery,
+		"production_grid":        status.FlowProductionGrid,
+	}
+	for flow, active := range flows {
+		m.flow.WithLabelValues(name, flow).Set(boolValue(active))
+	}
+
+	m.systemInfo.DeletePartialMatch(prometheus.Labels{"name": name})
+	m.systemInfo.WithLabelValues(name, status.SystemStatus).Set(1)
+
+	if ts, err := time.ParseInLocation(controllerTimeLayout, status.Timestamp, time.Local); err == nil {
+		m.timestampAge.WithLabelValues(name).Set(time.Since(ts).Seconds())
+	}
+}
+
+func (p *Prometheus) AddEnergy(name, schedule string, discharged, charged float64) {
+	p.status.energyDischarged.WithLabelValues(name, schedule).Add(discharged)
+	p.status.energyCharged.WithLabelValues(name, schedule).Add(charged)
+}
@@ -1,13 +1,38 @@
 package server
 
 import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gok-pi/metrics/health"
 	"net/http"
+	"time"
 )
 
-func Listen(ip, port string) error {
+// Listen serves the metrics of gatherer on /metrics, liveness on /healthz and, if health
+// is not nil, readiness of the battery workers on /readyz.
+func Listen(ip, port string, gatherer prometheus.Gatherer, health *health.Registry) error {
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+	})
+	if health != nil {
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			report := health.Check(time.Now())
+			code := http.StatusOK
+			if !report.Ready {
+				code = http.StatusServiceUnavailable
+			}
+			writeJSON(w, code, report)
+		})
+	}
 	address := ip + ":" + port
 	return http.ListenAndServe(address, mux)
 }
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}